@@ -0,0 +1,180 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Manifest lists the content-addressed chunks that a large value was split
+// into. It is itself stored as an immutable value and its key is the key of
+// the large value.
+type Manifest struct {
+	Size      int64    `json:"size"`
+	ChunkSize int      `json:"chunk_size"`
+	Chunks    []string `json:"chunks"`
+}
+
+// StoreLarge splits the value read from r into chunks, stores them in the DHT
+// in parallel and returns the key of a manifest listing them
+func (node *Node) StoreLarge(r io.Reader, ttl time.Duration) (string, error) {
+	return node.storeLarge(r, StoreArgs{Source: node.addr, Published: time.Now(), TTL: ttl})
+}
+
+// storeLarge is StoreLarge with the rest of the STORE arguments for each chunk
+// and the manifest taken from template
+func (node *Node) storeLarge(r io.Reader, template StoreArgs) (string, error) {
+	size := chunkSize
+	if node.config.MaxValueSize > 0 && node.config.MaxValueSize < size {
+		size = node.config.MaxValueSize
+	}
+	manifest := Manifest{ChunkSize: size, Chunks: make([]string, 0)}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var storeErr error
+	sem := make(chan bool, chunkParallelism)
+
+	for {
+		mu.Lock()
+		failed := storeErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+
+		chunk := make([]byte, size)
+		n, err := io.ReadFull(r, chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			mu.Lock()
+			storeErr = err
+			mu.Unlock()
+			break
+		}
+		chunk = chunk[:n]
+
+		key := ContentKey(chunk)
+		manifest.Chunks = append(manifest.Chunks, key)
+		manifest.Size += int64(n)
+
+		sem <- true
+		wg.Add(1)
+		go func(key string, chunk []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			args := template
+			args.Key = key
+			args.Val = chunk
+			args.Immutable = true
+			if err := node.publish(args); err != nil {
+				mu.Lock()
+				if storeErr == nil {
+					storeErr = fmt.Errorf("storing chunk %s: %s", key, err)
+				}
+				mu.Unlock()
+			}
+		}(key, chunk)
+
+		if n < size {
+			break
+		}
+	}
+	wg.Wait()
+	if storeErr != nil {
+		return "", storeErr
+	}
+
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	args := template
+	args.Key = ContentKey(encoded)
+	args.Val = encoded
+	args.Immutable = true
+	if err := node.publish(args); err != nil {
+		return "", fmt.Errorf("storing manifest: %s", err)
+	}
+
+	node.logs.store.Info("Stored chunked value", "key", args.Key, "bytes", manifest.Size, "chunks", len(manifest.Chunks))
+	return args.Key, nil
+}
+
+// FetchLarge looks up the manifest stored under key and writes the value it
+// describes to w, checking each chunk against its key
+func (node *Node) FetchLarge(key string, w io.Writer) error {
+	manifest, err := node.fetchManifest(key)
+	if err != nil {
+		return err
+	}
+	return node.fetchChunks(manifest, w)
+}
+
+// fetchManifest looks up and decodes the manifest stored under key
+func (node *Node) fetchManifest(key string) (*Manifest, error) {
+	encoded := node.doIterativeFindValue(key, true)
+	if encoded == nil {
+		return nil, fmt.Errorf("manifest %s not found", key)
+	}
+
+	manifest := new(Manifest)
+	if err := json.Unmarshal(encoded, manifest); err != nil {
+		return nil, fmt.Errorf("%s is not a manifest: %s", key, err)
+	}
+	if manifest.ChunkSize <= 0 || manifest.Size > int64(manifest.ChunkSize)*int64(len(manifest.Chunks)) {
+		return nil, errors.New("manifest is inconsistent")
+	}
+	return manifest, nil
+}
+
+// fetchChunks looks up the chunks in manifest, up to chunkParallelism at a
+// time, and writes them to w in order
+func (node *Node) fetchChunks(manifest *Manifest, w io.Writer) error {
+	results := make([]chan []byte, len(manifest.Chunks))
+	for i := range results {
+		results[i] = make(chan []byte, 1)
+	}
+
+	// fetch ahead of the writer, but only chunkParallelism chunks at a time
+	sem := make(chan bool, chunkParallelism)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for i, key := range manifest.Chunks {
+			select {
+			case sem <- true:
+			case <-done:
+				return
+			}
+			go func(i int, key string) {
+				results[i] <- node.doIterativeFindValue(key, true)
+			}(i, key)
+		}
+	}()
+
+	var written int64
+	for i, key := range manifest.Chunks {
+		chunk := <-results[i]
+		<-sem
+		if chunk == nil {
+			return fmt.Errorf("chunk %d (%s) not found", i, key)
+		}
+		n, err := w.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	if written != manifest.Size {
+		return fmt.Errorf("got %d bytes, manifest says %d", written, manifest.Size)
+	}
+	return nil
+}
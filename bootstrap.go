@@ -0,0 +1,121 @@
+package kademlia
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// JoinSeeds joins the network through seeds, a list of host:port addresses.
+// Each attempt pings the seeds, joinSeeds at a time in random order, until
+// some of them answer, then looks up our own ID to fill the routing table.
+// Failed attempts are retried after a backoff that starts at
+// config.JoinBackoff and doubles up to maxJoinBackoff, until the routing table
+// holds config.MinContacts contacts. It returns an error once
+// config.JoinAttempts attempts have failed, or never if that is 0. With no
+// seeds we start a new network.
+func (node *Node) JoinSeeds(seeds []string) error {
+	if len(seeds) == 0 {
+		node.Join("")
+		return nil
+	}
+
+	backoff := node.config.JoinBackoff
+	for attempt := 1; ; attempt++ {
+		// seeds are resolved again each time, in case DNS has caught up
+		answered := node.pingSeeds(node.resolveSeeds(seeds))
+		if answered > 0 {
+			for _, contact := range node.doIterativeFindNode(node.id.String()) {
+				node.rt.add(contact)
+			}
+		}
+		contacts := node.rt.seenContacts()
+		if contacts > 0 && contacts >= node.config.MinContacts {
+			atomic.StoreInt32(&node.joinState, joined)
+			node.logs.routing.Infof("Joined the network after %d attempts, with %d contacts", attempt, contacts)
+			return nil
+		}
+
+		if node.config.JoinAttempts > 0 && attempt >= node.config.JoinAttempts {
+			return fmt.Errorf("couldn't join the network in %d attempts: %d of %d seeds answered the last, and the routing table holds %d of %d contacts",
+				attempt, answered, len(seeds), contacts, node.config.MinContacts)
+		}
+		wait := jitter(backoff)
+		node.logs.routing.Warnf("Join attempt %d failed, %d seeds answered and the routing table holds %d of %d contacts. Retrying in %s",
+			attempt, answered, contacts, node.config.MinContacts, wait)
+		time.Sleep(wait)
+		if backoff *= 2; backoff > maxJoinBackoff {
+			backoff = maxJoinBackoff
+		}
+	}
+}
+
+// jitter returns a random duration between half and one and a half times d,
+// so that nodes started together don't retry together
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+// resolveSeeds returns the address of every node in seeds. A host name with
+// several addresses, such as a DNS name with a record for each seed node,
+// gives a seed for each. Seeds that don't resolve are skipped, as is our own
+// address.
+func (node *Node) resolveSeeds(seeds []string) []net.TCPAddr {
+	seen := map[string]bool{node.addr.String(): true}
+	result := make([]net.TCPAddr, 0, len(seeds))
+	for _, seed := range seeds {
+		host, port, err := net.SplitHostPort(seed)
+		if err != nil {
+			node.logs.routing.Warnf("Bad seed %q: %s", seed, err)
+			continue
+		}
+		hosts, err := net.LookupHost(host)
+		if err != nil {
+			node.logs.routing.Warnf("Couldn't resolve seed %s: %s", seed, err)
+			continue
+		}
+		for _, h := range hosts {
+			addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(h, port))
+			if err != nil || seen[addr.String()] {
+				continue
+			}
+			seen[addr.String()] = true
+			result = append(result, *addr)
+		}
+	}
+	return result
+}
+
+// pingSeeds pings seeds joinSeeds at a time, in random order, until some of a
+// batch answer, and returns how many did. Seeds that answer are added to the
+// routing table.
+func (node *Node) pingSeeds(seeds []net.TCPAddr) int {
+	rand.Shuffle(len(seeds), func(i, j int) {
+		seeds[i], seeds[j] = seeds[j], seeds[i]
+	})
+	for start := 0; start < len(seeds); start += joinSeeds {
+		batch := seeds[start:]
+		if len(batch) > joinSeeds {
+			batch = batch[:joinSeeds]
+		}
+		results := make(chan bool, len(batch))
+		node.transport.Parallel(len(batch), func(i int) {
+			results <- node.doPing(batch[i])
+		})
+		answered := 0
+		for range batch {
+			if <-results {
+				answered++
+			}
+		}
+		if answered > 0 {
+			return answered
+		}
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package kademlia
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolveSeeds(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	got := node.resolveSeeds([]string{
+		"10.0.0.1:4000",
+		"10.0.0.2:4000",
+		"10.0.0.1:4000",
+		// our own address
+		"10.1.0.1:4000",
+		"localhost:4000",
+		"no port",
+		"10.0.0.3:port",
+	})
+	want := map[string]bool{"10.0.0.1:4000": true, "10.0.0.2:4000": true, "127.0.0.1:4000": true}
+	found := make(map[string]bool)
+	for _, addr := range got {
+		found[addr.String()] = true
+	}
+	for addr := range want {
+		if !found[addr] {
+			t.Errorf("%s is missing from %v", addr, got)
+		}
+	}
+	if len(got) != len(found) {
+		t.Errorf("duplicate seeds in %v", got)
+	}
+	for addr := range found {
+		if ip := net.ParseIP(addr[:len(addr)-len(":4000")]); !want[addr] && (ip == nil || !ip.IsLoopback()) {
+			t.Errorf("unexpected seed %s", addr)
+		}
+	}
+}
+
+// flakyTransport fails the first failures RPCs it is asked to send
+type flakyTransport struct {
+	localTransport
+	failures *int32
+}
+
+func (t flakyTransport) Call(method string, dest net.TCPAddr, args interface{}, reply interface{}) bool {
+	if atomic.AddInt32(t.failures, -1) >= 0 {
+		return false
+	}
+	return t.localTransport.Call(method, dest, args, reply)
+}
+
+// joiner returns a quiet node that joins through transport, with a short
+// backoff
+func joiner(t *testing.T, transport Transport, minContacts int, attempts int) *Node {
+	config := DefaultConfig()
+	config.MinContacts = minContacts
+	config.JoinAttempts = attempts
+	config.JoinBackoff = time.Millisecond
+	node := NewNodeWithTransport("10.1.0.1:4000", config, transport)
+	if node == nil {
+		t.Fatal("couldn't create the joining node")
+	}
+	node.SetLogger(log.New(ioutil.Discard, "", 0))
+	return node
+}
+
+func TestJoinSeeds(t *testing.T) {
+	nodes, dead := chain(t, 3)
+	seeds := []string{dead.String(), nodes[1].addr.String(), "10.0.9.9:4000"}
+
+	// seeds that don't answer are passed over for the one that does
+	node := joiner(t, nodes[0].transport, 2, 1)
+	if err := node.JoinSeeds(seeds); err != nil {
+		t.Fatal(err)
+	}
+	if ready := node.readiness(); !ready.Ready {
+		t.Errorf("joined node isn't ready: %+v", ready)
+	}
+	if node.rt.ContactFromID(nodes[2].id) == nil {
+		t.Error("the join didn't find the node the seed knows of")
+	}
+
+	// the first attempts fail, and are retried
+	failures := int32(12)
+	node = joiner(t, flakyTransport{nodes[0].transport.(localTransport), &failures}, 2, 0)
+	if err := node.JoinSeeds(seeds); err != nil {
+		t.Fatal(err)
+	}
+	if ready := node.readiness(); !ready.Ready || failures >= 0 {
+		t.Errorf("node joined with %d failures to go: %+v", failures+1, ready)
+	}
+
+	// a network smaller than MinContacts is never joined
+	node = joiner(t, nodes[0].transport, 10, 3)
+	if err := node.JoinSeeds(seeds); err == nil {
+		t.Error("join with too few contacts succeeded")
+	}
+	if ready := node.readiness(); ready.Ready || ready.Contacts == 0 {
+		t.Errorf("node that couldn't join reported %+v", ready)
+	}
+
+	// nor is one that doesn't answer
+	node = joiner(t, failTransport{}, 1, 2)
+	if err := node.JoinSeeds(seeds); err == nil || len(node.rt.contacts()) != 0 {
+		t.Errorf("join with no answers returned %v, with %d contacts", err, len(node.rt.contacts()))
+	}
+
+	// with no seeds we start a network of our own
+	node = joiner(t, failTransport{}, 1, 1)
+	if err := node.JoinSeeds(nil); err != nil || !node.readiness().Ready {
+		t.Errorf("starting a network returned %v, %+v", err, node.readiness())
+	}
+}
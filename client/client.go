@@ -0,0 +1,439 @@
+// Package client is a Go client for the node control API (the /v1/ endpoints
+// served by each Kademlia node).
+//
+// A Client is given one or more node endpoints. Requests go to the endpoint
+// that last answered, and fail over to the others when a node can't be reached
+// or is temporarily unable to serve the request.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Contact is a node in the DHT
+type Contact struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// StoreOptions are the optional arguments to Store and StoreHere
+type StoreOptions struct {
+	// TTL is how long the value should live, zero for the node's default
+	TTL time.Duration
+	// Secret must be presented to delete the value
+	Secret string
+	// Quorum is how many nodes must accept the value for Store to succeed,
+	// zero for one
+	Quorum int
+}
+
+// OneshotValue is the reply of a single node to a FINDVALUE
+type OneshotValue struct {
+	// Value is nil if the node doesn't have the key
+	Value []byte `json:"value"`
+	// Contacts are the nodes closest to the key that the node knows of
+	Contacts []Contact `json:"contacts"`
+	// Seq is set if Value is a signed mutable value
+	Seq     *int64 `json:"seq,omitempty"`
+	Deleted bool   `json:"deleted"`
+}
+
+// TableEntry is a key held by a node
+type TableEntry struct {
+	Key       string `json:"key"`
+	Name      string `json:"name,omitempty"`
+	Value     []byte `json:"value"`
+	IsOrigin  bool   `json:"is_origin"`
+	Deleted   bool   `json:"deleted"`
+	Cached    bool   `json:"cached"`
+	Immutable bool   `json:"immutable"`
+	Seq       *int64 `json:"seq,omitempty"`
+	// TTL is how much longer the value will live, in seconds
+	TTL       int64  `json:"ttl"`
+	Publisher string `json:"publisher,omitempty"`
+}
+
+// Lookup is the result of an iterative FINDVALUE
+type Lookup struct {
+	Value []byte `json:"value"`
+	// Hops is the number of rounds of RPCs the lookup took
+	Hops int `json:"hops"`
+	// RPCs is the number of FINDVALUE RPCs sent
+	RPCs int `json:"rpcs"`
+	// Local is set if the node asked held the value itself
+	Local bool `json:"local"`
+	// Cached is set if the value came from a copy cached by an earlier lookup
+	Cached bool `json:"cached"`
+}
+
+// Bucket is a non-empty k-bucket in a node's routing table. It holds the IDs
+// from First to Last, which share the node's ID in their first PrefixLen-1
+// bits and differ from it in the next.
+type Bucket struct {
+	Index        int              `json:"index"`
+	PrefixLen    int              `json:"prefix_len"`
+	First        string           `json:"first"`
+	Last         string           `json:"last"`
+	Contacts     []RoutingContact `json:"contacts"`
+	Replacements []RoutingContact `json:"replacements"`
+}
+
+// RoutingContact is a contact in a routing table and how it has been
+// answering
+type RoutingContact struct {
+	Contact
+	// LastSeen is nil if the node has only heard of the contact from others
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	// Failures is the number of RPCs in a row it hasn't answered
+	Failures int `json:"failures"`
+	// RTTMs is the smoothed round trip time of the RPCs it has answered
+	RTTMs float64 `json:"rtt_ms,omitempty"`
+}
+
+// Routing is a node's routing table
+type Routing struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	// Contacts is the number of contacts in Buckets, not counting
+	// replacements
+	Contacts int      `json:"contacts"`
+	Buckets  []Bucket `json:"buckets"`
+}
+
+// Stats summarizes the state of a node
+type Stats struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	// Keys includes tombstones, cached copies and keys the node originated
+	Keys       int   `json:"keys"`
+	Bytes      int64 `json:"bytes"`
+	Tombstones int   `json:"tombstones"`
+	Cached     int   `json:"cached"`
+	Origin     int   `json:"origin"`
+	// Contacts is the size of the routing table, spread over Buckets
+	// non-empty buckets
+	Contacts int `json:"contacts"`
+	Buckets  int `json:"buckets"`
+}
+
+// Error is an error returned by a node
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// IsNotFound returns true if err means a key, name or contact doesn't exist
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Client sends requests to the control API of a set of nodes. It's safe for
+// concurrent use.
+type Client struct {
+	// HTTPClient is used to send requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+	// Retries is how many more times each endpoint is tried after a request
+	// fails on all of them
+	Retries int
+	// RetryDelay is the pause between rounds of retries
+	RetryDelay time.Duration
+
+	endpoints []string
+	mu        sync.Mutex
+	current   int
+}
+
+// New returns a Client for the nodes at endpoints, given as "host:port" or as
+// URLs
+func New(endpoints ...string) *Client {
+	c := &Client{Retries: 2, RetryDelay: 500 * time.Millisecond}
+	for _, endpoint := range endpoints {
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+		c.endpoints = append(c.endpoints, strings.TrimSuffix(endpoint, "/"))
+	}
+	return c
+}
+
+// Endpoints returns the endpoints the client sends requests to
+func (c *Client) Endpoints() []string {
+	return append([]string(nil), c.endpoints...)
+}
+
+// PingIP asks a node to PING the node at addr
+func (c *Client) PingIP(ctx context.Context, addr string) error {
+	return c.do(ctx, "GET", "/v1/ping/ip/"+addr, nil, nil)
+}
+
+// PingID asks a node to PING the node with the given ID from its routing
+// table, and returns its contact
+func (c *Client) PingID(ctx context.Context, id string) (Contact, error) {
+	var contact Contact
+	err := c.do(ctx, "GET", "/v1/ping/id/"+id, nil, &contact)
+	return contact, err
+}
+
+type storeRequest struct {
+	Value  []byte `json:"value"`
+	TTL    string `json:"ttl,omitempty"`
+	Secret string `json:"secret,omitempty"`
+	Quorum int    `json:"quorum,omitempty"`
+}
+
+type storeReply struct {
+	Key      string `json:"key"`
+	Replicas int    `json:"replicas"`
+}
+
+func newStoreRequest(value []byte, opts *StoreOptions) storeRequest {
+	req := storeRequest{Value: value}
+	if opts != nil {
+		if opts.TTL > 0 {
+			req.TTL = opts.TTL.String()
+		}
+		req.Secret = opts.Secret
+		req.Quorum = opts.Quorum
+	}
+	return req
+}
+
+// Store stores value under key on the nodes closest to it, and returns how
+// many accepted it. opts may be nil.
+func (c *Client) Store(ctx context.Context, key string, value []byte, opts *StoreOptions) (int, error) {
+	var reply storeReply
+	err := c.do(ctx, "PUT", "/v1/store/"+key, newStoreRequest(value, opts), &reply)
+	return reply.Replicas, err
+}
+
+// StoreHere stores value under key on whichever node answers the request,
+// without publishing it to the rest of the DHT. opts may be nil.
+func (c *Client) StoreHere(ctx context.Context, key string, value []byte, opts *StoreOptions) error {
+	return c.do(ctx, "PUT", "/v1/store_here/"+key, newStoreRequest(value, opts), nil)
+}
+
+// StoreName stores value under the key for name, and returns the key. opts may
+// be nil.
+func (c *Client) StoreName(ctx context.Context, name string, value []byte, opts *StoreOptions) (string, error) {
+	var reply storeReply
+	err := c.do(ctx, "PUT", "/v1/name/"+url.PathEscape(name), newStoreRequest(value, opts), &reply)
+	return reply.Key, err
+}
+
+// Delete deletes key, which was stored with secret, and returns how many
+// nodes accepted the delete
+func (c *Client) Delete(ctx context.Context, key string, secret string) (int, error) {
+	var reply storeReply
+	err := c.do(ctx, "DELETE", "/v1/store/"+key, map[string]string{"secret": secret}, &reply)
+	return reply.Replicas, err
+}
+
+// FindNode looks up the k nodes closest to id
+func (c *Client) FindNode(ctx context.Context, id string) ([]Contact, error) {
+	var reply struct {
+		Contacts []Contact `json:"contacts"`
+	}
+	err := c.do(ctx, "GET", "/v1/iterative/findnode/"+id, nil, &reply)
+	return reply.Contacts, err
+}
+
+// FindNodeVia asks only the node at addr for the nodes it knows closest to id
+func (c *Client) FindNodeVia(ctx context.Context, id string, addr string) ([]Contact, error) {
+	var reply struct {
+		Contacts []Contact `json:"contacts"`
+	}
+	err := c.do(ctx, "GET", "/v1/oneshot/findnode/"+id+"?node="+url.QueryEscape(addr), nil, &reply)
+	return reply.Contacts, err
+}
+
+// FindValue looks up the value stored under key. The error satisfies
+// IsNotFound if there isn't one.
+func (c *Client) FindValue(ctx context.Context, key string) ([]byte, error) {
+	return c.FindValueQuorum(ctx, key, 1)
+}
+
+// FindValueQuorum looks up the value stored under key, waiting for r nodes to
+// return it and taking the newest
+func (c *Client) FindValueQuorum(ctx context.Context, key string, r int) ([]byte, error) {
+	path := "/v1/iterative/findvalue/" + key
+	if r > 1 {
+		path += fmt.Sprintf("?r=%d", r)
+	}
+	var reply struct {
+		Value []byte `json:"value"`
+	}
+	err := c.do(ctx, "GET", path, nil, &reply)
+	return reply.Value, err
+}
+
+// FindValueLookup is FindValue, also returning how the lookup went
+func (c *Client) FindValueLookup(ctx context.Context, key string) (Lookup, error) {
+	var lookup Lookup
+	err := c.do(ctx, "GET", "/v1/iterative/findvalue/"+key, nil, &lookup)
+	return lookup, err
+}
+
+// FindValueVia asks only the node at addr for the value stored under key
+func (c *Client) FindValueVia(ctx context.Context, key string, addr string) (OneshotValue, error) {
+	var reply OneshotValue
+	err := c.do(ctx, "GET", "/v1/oneshot/findvalue/"+key+"?node="+url.QueryEscape(addr), nil, &reply)
+	return reply, err
+}
+
+// FindName looks up the value stored under name
+func (c *Client) FindName(ctx context.Context, name string) ([]byte, error) {
+	var reply struct {
+		Value []byte `json:"value"`
+	}
+	err := c.do(ctx, "GET", "/v1/name/"+url.PathEscape(name), nil, &reply)
+	return reply.Value, err
+}
+
+// Table returns the keys held by a node
+func (c *Client) Table(ctx context.Context) ([]TableEntry, error) {
+	var reply struct {
+		Entries []TableEntry `json:"entries"`
+	}
+	err := c.do(ctx, "GET", "/v1/table", nil, &reply)
+	return reply.Entries, err
+}
+
+// Routing returns a node's routing table
+func (c *Client) Routing(ctx context.Context) (Routing, error) {
+	var routing Routing
+	err := c.do(ctx, "GET", "/v1/routing", nil, &routing)
+	return routing, err
+}
+
+// Stats returns a summary of a node's state
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	err := c.do(ctx, "GET", "/v1/stats", nil, &stats)
+	return stats, err
+}
+
+// Shutdown stops the node at endpoint, which must be one of the client's
+// endpoints. Shutdowns are never retried on another node.
+func (c *Client) Shutdown(ctx context.Context, endpoint string) error {
+	for _, e := range c.endpoints {
+		if e == endpoint || e == "http://"+endpoint {
+			_, err := c.send(ctx, e, "POST", "/v1/shutdown", nil, nil)
+			return err
+		}
+	}
+	return fmt.Errorf("%s is not one of the client's endpoints", endpoint)
+}
+
+// do sends a request, failing over between endpoints and retrying until one
+// succeeds, the request fails with a permanent error or ctx is done. body and
+// out are JSON encoded and decoded, and may be nil.
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	if len(c.endpoints) == 0 {
+		return errors.New("client has no endpoints")
+	}
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	start := c.current
+	c.mu.Unlock()
+
+	var lastErr error
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.RetryDelay):
+			}
+		}
+		for i := range c.endpoints {
+			index := (start + i) % len(c.endpoints)
+			retry, err := c.send(ctx, c.endpoints[index], method, path, payload, out)
+			if err == nil || !retry {
+				c.mu.Lock()
+				c.current = index
+				c.mu.Unlock()
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// send sends one request to endpoint, returning whether it's worth retrying if
+// it fails
+func (c *Client) send(ctx context.Context, endpoint string, method string, path string, payload []byte, out interface{}) (bool, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+path, body)
+	if err != nil {
+		return false, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &Error{StatusCode: resp.StatusCode, Code: "unknown", Message: resp.Status}
+		var errBody struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&errBody) == nil && errBody.Error.Code != "" {
+			apiErr.Code = errBody.Error.Code
+			apiErr.Message = errBody.Error.Message
+		}
+		// the node is overloaded, failed a quorum or timed out, another may do
+		// better
+		retry := resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout
+		return retry, apiErr
+	}
+
+	if out == nil {
+		return false, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return false, fmt.Errorf("decoding reply from %s: %s", endpoint, err)
+	}
+	return false, nil
+}
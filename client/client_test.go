@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// node is a fake node that answers every request the same way
+type node struct {
+	*httptest.Server
+	hits  int32
+	paths []string
+}
+
+func newNode(t *testing.T, behaviour string) *node {
+	n := new(node)
+	n.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n.hits, 1)
+		n.paths = append(n.paths, r.Method+" "+r.URL.Path)
+		switch behaviour {
+		case "ok":
+			w.Write([]byte(`{"id":"ok"}`))
+		case "closed":
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+		default:
+			status := map[string]int{"400": 400, "404": 404, "503": 503, "504": 504}[behaviour]
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error":{"code":"code` + behaviour + `","message":"failed"}}`))
+		}
+	}))
+	t.Cleanup(n.Close)
+	return n
+}
+
+func TestFailover(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []string
+		// hits is how many requests each node gets
+		hits   []int32
+		status int
+		ok     bool
+	}{
+		{"first answers", []string{"ok", "503"}, []int32{1, 0}, 0, true},
+		{"overloaded", []string{"503", "ok"}, []int32{1, 1}, 0, true},
+		{"timed out", []string{"504", "ok"}, []int32{1, 1}, 0, true},
+		{"unreachable", []string{"closed", "503", "ok"}, []int32{1, 1, 1}, 0, true},
+		{"not found", []string{"404", "ok"}, []int32{1, 0}, 404, false},
+		{"bad request", []string{"400", "ok"}, []int32{1, 0}, 400, false},
+		{"all fail, with retries", []string{"503", "504"}, []int32{3, 3}, 504, false},
+		{"all unreachable", []string{"closed", "closed"}, []int32{3, 3}, 0, false},
+	}
+	for _, test := range tests {
+		nodes := make([]*node, len(test.nodes))
+		endpoints := make([]string, len(test.nodes))
+		for i, behaviour := range test.nodes {
+			nodes[i] = newNode(t, behaviour)
+			endpoints[i] = nodes[i].URL
+		}
+		c := New(endpoints...)
+		c.RetryDelay = 0
+
+		stats, err := c.Stats(context.Background())
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+		if test.ok && stats.ID != "ok" {
+			t.Errorf("%s: got %+v", test.name, stats)
+		}
+		if apiErr, _ := err.(*Error); test.status != 0 && (apiErr == nil || apiErr.StatusCode != test.status) {
+			t.Errorf("%s: got error %v, want status %d", test.name, err, test.status)
+		}
+		for i, n := range nodes {
+			if hits := atomic.LoadInt32(&n.hits); hits != test.hits[i] {
+				t.Errorf("%s: node %d (%s) got %d requests, want %d", test.name, i, test.nodes[i], hits, test.hits[i])
+			}
+		}
+	}
+}
+
+func TestFailoverSticks(t *testing.T) {
+	down, up := newNode(t, "503"), newNode(t, "ok")
+	c := New(down.URL, up.URL)
+	c.RetryDelay = 0
+	for i := 0; i < 3; i++ {
+		if _, err := c.Stats(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// once a node answers, requests go to it first
+	if down.hits != 1 || up.hits != 3 {
+		t.Errorf("nodes got %d and %d requests, want 1 and 3", down.hits, up.hits)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	first, second, down := newNode(t, "ok"), newNode(t, "ok"), newNode(t, "503")
+	c := New(first.URL, strings.TrimPrefix(second.URL, "http://"), down.URL)
+	c.RetryDelay = 0
+
+	// only the endpoint named is shut down, given either way
+	if err := c.Shutdown(context.Background(), strings.TrimPrefix(second.URL, "http://")); err != nil {
+		t.Fatal(err)
+	}
+	if first.hits != 0 || second.hits != 1 || second.paths[0] != "POST /v1/shutdown" {
+		t.Errorf("shutdown sent %d requests to the first node and %v to the second", first.hits, second.paths)
+	}
+	// and it isn't retried, or sent anywhere else, if that node fails
+	if err := c.Shutdown(context.Background(), down.URL); err == nil {
+		t.Error("shutdown of a node that answered 503 succeeded")
+	}
+	if down.hits != 1 || first.hits != 0 || second.hits != 1 {
+		t.Errorf("failed shutdown sent %d, %d and %d requests", first.hits, second.hits, down.hits)
+	}
+	if err := c.Shutdown(context.Background(), "10.0.0.1:4000"); err == nil {
+		t.Error("shutdown of an unknown endpoint succeeded")
+	}
+}
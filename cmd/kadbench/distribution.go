@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// distribution picks key indexes in [0, n) with some popularity distribution
+type distribution struct {
+	// cdf[i] is the probability of picking an index <= i
+	cdf []float64
+}
+
+// newDistribution returns the distribution named by kind over n keys.
+// zipf gives key i a weight of 1/(i+1)^alpha. linear weights fall in a straight
+// line from 1 for the first key to 1/m for the last, so m = 1 is uniform.
+func newDistribution(kind string, n int, alpha float64, m float64) (*distribution, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("need at least one key")
+	}
+
+	weights := make([]float64, n)
+	switch kind {
+	case "zipf":
+		for i := range weights {
+			weights[i] = 1 / math.Pow(float64(i+1), alpha)
+		}
+	case "uniform":
+		for i := range weights {
+			weights[i] = 1
+		}
+	case "linear":
+		if m < 1 {
+			return nil, fmt.Errorf("linear distribution needs m >= 1, got %g", m)
+		}
+		for i := range weights {
+			position := 0.0
+			if n > 1 {
+				position = float64(i) / float64(n-1)
+			}
+			weights[i] = 1 - (1-1/m)*position
+		}
+	default:
+		return nil, fmt.Errorf("unknown distribution %q, use zipf, uniform or linear", kind)
+	}
+
+	d := &distribution{cdf: make([]float64, n)}
+	sum := 0.0
+	for i, weight := range weights {
+		sum += weight
+		d.cdf[i] = sum
+	}
+	for i := range d.cdf {
+		d.cdf[i] /= sum
+	}
+	return d, nil
+}
+
+// next returns a key index
+func (d *distribution) next(r *rand.Rand) int {
+	u := r.Float64()
+	i := sort.SearchFloat64s(d.cdf, u)
+	if i == len(d.cdf) {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,286 @@
+// kadbench drives the REST APIs of a set of Kademlia nodes with a store phase
+// followed by a retrieve phase, and reports latency, hop counts and cache hits.
+//
+// Keys are the SHA-1 of the key number, as in scripts/test.py, and values are
+// derived from -seed so a retrieve-only run can check what it gets back.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/peterdelong/kademlia/client"
+)
+
+// result is the outcome of one request
+type result struct {
+	latency time.Duration
+	err     error
+	// wrong is set if a retrieve returned a different value than was stored
+	wrong  bool
+	hops   int
+	cached bool
+	local  bool
+}
+
+// phaseReport summarizes one phase of the benchmark
+type phaseReport struct {
+	Phase      string  `json:"phase"`
+	Ops        int     `json:"ops"`
+	Failures   int     `json:"failures"`
+	Wrong      int     `json:"wrong"`
+	Seconds    float64 `json:"seconds"`
+	Throughput float64 `json:"ops_per_second"`
+	// latencies are in milliseconds
+	LatencyMean float64 `json:"latency_mean_ms"`
+	LatencyP50  float64 `json:"latency_p50_ms"`
+	LatencyP90  float64 `json:"latency_p90_ms"`
+	LatencyP99  float64 `json:"latency_p99_ms"`
+	LatencyMax  float64 `json:"latency_max_ms"`
+	// the rest are only reported for retrieves
+	HopsMean      float64     `json:"hops_mean,omitempty"`
+	HopsP50       float64     `json:"hops_p50,omitempty"`
+	HopsP99       float64     `json:"hops_p99,omitempty"`
+	HopsHistogram map[int]int `json:"hops_histogram,omitempty"`
+	CacheHitRatio float64     `json:"cache_hit_ratio"`
+	LocalRatio    float64     `json:"local_ratio"`
+}
+
+// keyFor returns the key for key number i
+func keyFor(i int) string {
+	hash := sha1.Sum([]byte(strconv.Itoa(i)))
+	return hex.EncodeToString(hash[:])
+}
+
+// valueFor returns the value stored under key number i
+func valueFor(seed int64, i int, size int) []byte {
+	value := make([]byte, size)
+	rand.New(rand.NewSource(seed + int64(i))).Read(value)
+	return value
+}
+
+// percentile returns the p-th percentile of sorted
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(p / 100 * float64(len(sorted)-1))
+	return sorted[index]
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// summarize builds the report for a phase
+func summarize(phase string, results []result, elapsed time.Duration) phaseReport {
+	report := phaseReport{Phase: phase, Ops: len(results), Seconds: elapsed.Seconds()}
+	if elapsed > 0 {
+		report.Throughput = float64(len(results)) / elapsed.Seconds()
+	}
+
+	var latencies, hops []float64
+	cached, local := 0, 0
+	for _, r := range results {
+		latencies = append(latencies, float64(r.latency)/float64(time.Millisecond))
+		if r.err != nil {
+			report.Failures++
+			continue
+		}
+		if r.wrong {
+			report.Wrong++
+		}
+		if phase == "retrieve" {
+			hops = append(hops, float64(r.hops))
+			if r.cached {
+				cached++
+			}
+			if r.local {
+				local++
+			}
+		}
+	}
+
+	sort.Float64s(latencies)
+	report.LatencyMean = mean(latencies)
+	report.LatencyP50 = percentile(latencies, 50)
+	report.LatencyP90 = percentile(latencies, 90)
+	report.LatencyP99 = percentile(latencies, 99)
+	report.LatencyMax = percentile(latencies, 100)
+
+	if len(hops) > 0 {
+		sort.Float64s(hops)
+		report.HopsMean = mean(hops)
+		report.HopsP50 = percentile(hops, 50)
+		report.HopsP99 = percentile(hops, 99)
+		report.HopsHistogram = make(map[int]int)
+		for _, h := range hops {
+			report.HopsHistogram[int(h)]++
+		}
+		report.CacheHitRatio = float64(cached) / float64(len(hops))
+		report.LocalRatio = float64(local) / float64(len(hops))
+	}
+	return report
+}
+
+// run sends count requests with do from concurrency workers
+func run(count int, concurrency int, do func(i int) result) ([]result, time.Duration) {
+	results := make([]result, count)
+	work := make(chan int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i] = do(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return results, time.Since(start)
+}
+
+func printReports(format string, reports []phaseReport) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reports)
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"phase", "ops", "failures", "wrong", "seconds", "ops_per_second",
+			"latency_mean_ms", "latency_p50_ms", "latency_p90_ms", "latency_p99_ms", "latency_max_ms",
+			"hops_mean", "hops_p50", "hops_p99", "cache_hit_ratio", "local_ratio"})
+		f := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+		for _, r := range reports {
+			w.Write([]string{r.Phase, strconv.Itoa(r.Ops), strconv.Itoa(r.Failures), strconv.Itoa(r.Wrong),
+				f(r.Seconds), f(r.Throughput), f(r.LatencyMean), f(r.LatencyP50), f(r.LatencyP90),
+				f(r.LatencyP99), f(r.LatencyMax), f(r.HopsMean), f(r.HopsP50), f(r.HopsP99),
+				f(r.CacheHitRatio), f(r.LocalRatio)})
+		}
+		w.Flush()
+		return w.Error()
+	case "text":
+		for _, r := range reports {
+			fmt.Printf("%s: %d ops in %.2fs (%.1f ops/s), %d failed, %d wrong\n",
+				r.Phase, r.Ops, r.Seconds, r.Throughput, r.Failures, r.Wrong)
+			fmt.Printf("  latency ms: mean %.2f  p50 %.2f  p90 %.2f  p99 %.2f  max %.2f\n",
+				r.LatencyMean, r.LatencyP50, r.LatencyP90, r.LatencyP99, r.LatencyMax)
+			if r.HopsHistogram != nil {
+				fmt.Printf("  hops: mean %.2f  p50 %.0f  p99 %.0f  histogram %v\n",
+					r.HopsMean, r.HopsP50, r.HopsP99, r.HopsHistogram)
+				fmt.Printf("  cache hits %.1f%%, local %.1f%%\n", 100*r.CacheHitRatio, 100*r.LocalRatio)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, use text, json or csv", format)
+}
+
+func main() {
+	dist := flag.String("dist", "zipf", "key popularity: zipf, uniform or linear")
+	alpha := flag.Float64("alpha", 1, "exponent of the zipf distribution")
+	m := flag.Float64("m", 2, "ratio of the most to least popular key for the linear distribution")
+	keys := flag.Int("keys", 1000, "number of keys")
+	size := flag.Int("size", 128, "size of values in bytes")
+	times := flag.Int("times", 100, "number of retrieves")
+	concurrency := flag.Int("concurrency", 8, "requests in flight at once")
+	phase := flag.String("phase", "all", "phases to run: all, store or retrieve")
+	seed := flag.Int64("seed", 1, "seed for values and key choices")
+	format := flag.String("format", "text", "output format: text, json or csv")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout for each request")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: kadbench [flags] <node addr>...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || *concurrency < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *phase != "all" && *phase != "store" && *phase != "retrieve" {
+		fmt.Fprintf(os.Stderr, "kadbench: unknown phase %q\n", *phase)
+		os.Exit(2)
+	}
+	d, err := newDistribution(*dist, *keys, *alpha, *m)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kadbench: %s\n", err)
+		os.Exit(2)
+	}
+
+	// one client per node without failover, so each request measures the node
+	// it was sent to
+	nodes := make([]*client.Client, flag.NArg())
+	for i, addr := range flag.Args() {
+		nodes[i] = client.New(addr)
+		nodes[i].Retries = 0
+	}
+
+	var reports []phaseReport
+	if *phase != "retrieve" {
+		results, elapsed := run(*keys, *concurrency, func(i int) result {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+			start := time.Now()
+			_, err := nodes[i%len(nodes)].Store(ctx, keyFor(i), valueFor(*seed, i, *size), nil)
+			return result{latency: time.Since(start), err: err}
+		})
+		reports = append(reports, summarize("store", results, elapsed))
+	}
+
+	if *phase != "store" {
+		// choose the keys and nodes up front so the choices don't depend on
+		// the order workers run in
+		r := rand.New(rand.NewSource(*seed))
+		picks := make([][2]int, *times)
+		for i := range picks {
+			picks[i] = [2]int{d.next(r), r.Intn(len(nodes))}
+		}
+		results, elapsed := run(*times, *concurrency, func(i int) result {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+			key, node := picks[i][0], picks[i][1]
+			start := time.Now()
+			lookup, err := nodes[node].FindValueLookup(ctx, keyFor(key))
+			res := result{latency: time.Since(start), err: err}
+			if err == nil {
+				res.wrong = !bytes.Equal(lookup.Value, valueFor(*seed, key, *size))
+				res.hops = lookup.Hops
+				res.cached = lookup.Cached
+				res.local = lookup.Local
+			}
+			return res
+		})
+		reports = append(reports, summarize("retrieve", results, elapsed))
+	}
+
+	if err := printReports(*format, reports); err != nil {
+		fmt.Fprintf(os.Stderr, "kadbench: %s\n", err)
+		os.Exit(2)
+	}
+}
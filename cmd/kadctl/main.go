@@ -0,0 +1,418 @@
+// kadctl controls Kademlia nodes through their /v1/ REST API.
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/peterdelong/kademlia/client"
+)
+
+const usage = `usage: kadctl [flags] <command> [command flags] [args]
+
+commands:
+  store [-ttl d] [-secret s] [-quorum n] [-here] [-name] <key> [value]
+                 store value (or stdin) under key, or under the hash of a name
+  delete -secret s <key>
+                 delete a key stored with secret
+  ping (id | ip) <target>
+                 have the node ping another node
+  findnode [-via addr] <id>
+                 find the k closest nodes to id, or ask only the node at addr
+  findvalue [-via addr] [-r n] [-name] <key>
+                 find the value stored under key, or ask only the node at addr
+  table          list the keys held by the node
+  routing        dump the node's routing table
+  stats          summarize the node's state
+  shutdown       shut down every node given with -addr
+
+flags:
+`
+
+// options are the flags common to all commands
+type options struct {
+	json bool
+}
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:9001", "comma separated node addresses, tried in order")
+	jsonOut := flag.Bool("json", false, "print JSON instead of human readable output")
+	timeout := flag.Duration("timeout", time.Minute, "give up after this long")
+	retries := flag.Int("retries", 2, "times to retry every address after they all fail")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := client.New(strings.Split(*addr, ",")...)
+	c.Retries = *retries
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	opts := options{json: *jsonOut}
+
+	commands := map[string]func(context.Context, *client.Client, options, []string) error{
+		"store":     runStore,
+		"delete":    runDelete,
+		"ping":      runPing,
+		"findnode":  runFindNode,
+		"findvalue": runFindValue,
+		"table":     runTable,
+		"routing":   runRouting,
+		"stats":     runStats,
+		"shutdown":  runShutdown,
+	}
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "kadctl: unknown command %q\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := command(ctx, c, opts, flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "kadctl: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet returns a FlagSet for a command that exits on bad usage
+func newFlagSet(name string, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: kadctl %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// printJSON prints v indented
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printable returns val as a string if it's text, otherwise base64 encoded
+func printable(val []byte, limit int) string {
+	s := string(val)
+	if !utf8.Valid(val) || strings.ContainsAny(s, "\x00\r\n\t") {
+		s = "base64:" + base64.StdEncoding.EncodeToString(val)
+	}
+	if limit > 0 && len(s) > limit {
+		s = s[:limit] + "..."
+	}
+	return s
+}
+
+func runStore(ctx context.Context, c *client.Client, opts options, args []string) error {
+	fs := newFlagSet("store", "[flags] <key> [value]")
+	ttl := fs.Duration("ttl", 0, "how long the value should live, zero for the node's default")
+	secret := fs.String("secret", "", "secret needed to delete the value")
+	quorum := fs.Int("quorum", 1, "nodes that must accept the value")
+	here := fs.Bool("here", false, "store only on the node, without publishing")
+	name := fs.Bool("name", false, "key is a name to hash rather than a hex key")
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *here && *name {
+		fmt.Fprintln(os.Stderr, "kadctl: -here and -name can't be used together")
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	key := fs.Arg(0)
+	var value []byte
+	if fs.NArg() == 2 {
+		value = []byte(fs.Arg(1))
+	} else {
+		var err error
+		if value, err = ioutil.ReadAll(os.Stdin); err != nil {
+			return err
+		}
+	}
+
+	storeOpts := &client.StoreOptions{TTL: *ttl, Secret: *secret, Quorum: *quorum}
+	result := map[string]interface{}{}
+	switch {
+	case *here:
+		if err := c.StoreHere(ctx, key, value, storeOpts); err != nil {
+			return err
+		}
+		result["key"] = key
+		result["replicas"] = 1
+	case *name:
+		hashed, err := c.StoreName(ctx, key, value, storeOpts)
+		if err != nil {
+			return err
+		}
+		result["name"] = key
+		result["key"] = hashed
+	default:
+		replicas, err := c.Store(ctx, key, value, storeOpts)
+		if err != nil {
+			return err
+		}
+		result["key"] = key
+		result["replicas"] = replicas
+	}
+
+	if opts.json {
+		return printJSON(result)
+	}
+	if replicas, ok := result["replicas"]; ok {
+		fmt.Printf("Stored %s on %d nodes\n", result["key"], replicas)
+	} else {
+		fmt.Printf("Stored %q as %s\n", key, result["key"])
+	}
+	return nil
+}
+
+func runDelete(ctx context.Context, c *client.Client, opts options, args []string) error {
+	fs := newFlagSet("delete", "-secret <secret> <key>")
+	secret := fs.String("secret", "", "secret the value was stored with")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *secret == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	replicas, err := c.Delete(ctx, fs.Arg(0), *secret)
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		return printJSON(map[string]interface{}{"key": fs.Arg(0), "replicas": replicas})
+	}
+	fmt.Printf("Deleted %s on %d nodes\n", fs.Arg(0), replicas)
+	return nil
+}
+
+func runPing(ctx context.Context, c *client.Client, opts options, args []string) error {
+	if len(args) != 2 || (args[0] != "id" && args[0] != "ip") {
+		fmt.Fprintln(os.Stderr, "usage: kadctl ping (id | ip) <target>")
+		os.Exit(2)
+	}
+
+	contact := client.Contact{Address: args[1]}
+	var err error
+	if args[0] == "id" {
+		contact, err = c.PingID(ctx, args[1])
+	} else {
+		err = c.PingIP(ctx, args[1])
+	}
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		return printJSON(contact)
+	}
+	fmt.Printf("%s responded\n", args[1])
+	return nil
+}
+
+func printContacts(opts options, contacts []client.Contact) error {
+	if opts.json {
+		return printJSON(contacts)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS")
+	for _, contact := range contacts {
+		fmt.Fprintf(w, "%s\t%s\n", contact.ID, contact.Address)
+	}
+	return w.Flush()
+}
+
+func runFindNode(ctx context.Context, c *client.Client, opts options, args []string) error {
+	fs := newFlagSet("findnode", "[-via <addr>] <id>")
+	via := fs.String("via", "", "ask only the node at this address (a oneshot lookup)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var contacts []client.Contact
+	var err error
+	if *via != "" {
+		contacts, err = c.FindNodeVia(ctx, fs.Arg(0), *via)
+	} else {
+		contacts, err = c.FindNode(ctx, fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	return printContacts(opts, contacts)
+}
+
+func runFindValue(ctx context.Context, c *client.Client, opts options, args []string) error {
+	fs := newFlagSet("findvalue", "[flags] <key>")
+	via := fs.String("via", "", "ask only the node at this address (a oneshot lookup)")
+	replies := fs.Int("r", 1, "nodes that must return the value, the newest is taken")
+	name := fs.Bool("name", false, "key is a name to hash rather than a hex key")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	key := fs.Arg(0)
+
+	if *via != "" {
+		reply, err := c.FindValueVia(ctx, key, *via)
+		if err != nil {
+			return err
+		}
+		if opts.json {
+			return printJSON(reply)
+		}
+		switch {
+		case reply.Deleted:
+			fmt.Println("Deleted")
+		case reply.Value != nil:
+			fmt.Println(printable(reply.Value, 0))
+		default:
+			fmt.Println("Not held, closest nodes:")
+			return printContacts(opts, reply.Contacts)
+		}
+		return nil
+	}
+
+	var value []byte
+	var err error
+	if *name {
+		value, err = c.FindName(ctx, key)
+	} else {
+		value, err = c.FindValueQuorum(ctx, key, *replies)
+	}
+	if client.IsNotFound(err) {
+		return fmt.Errorf("%s not found", key)
+	}
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		return printJSON(map[string]interface{}{"key": key, "value": value})
+	}
+	// raw, so it can be piped
+	os.Stdout.Write(value)
+	return nil
+}
+
+func runTable(ctx context.Context, c *client.Client, opts options, args []string) error {
+	entries, err := c.Table(ctx)
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		return printJSON(entries)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tNAME\tSIZE\tTTL\tFLAGS\tVALUE")
+	for _, entry := range entries {
+		var flags []string
+		if entry.IsOrigin {
+			flags = append(flags, "origin")
+		}
+		if entry.Cached {
+			flags = append(flags, "cached")
+		}
+		if entry.Immutable {
+			flags = append(flags, "immutable")
+		}
+		if entry.Seq != nil {
+			flags = append(flags, fmt.Sprintf("seq=%d", *entry.Seq))
+		}
+		ttl := (time.Duration(entry.TTL) * time.Second).String()
+		if entry.Deleted {
+			flags = append(flags, "deleted")
+			ttl = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", entry.Key, entry.Name, len(entry.Value), ttl,
+			strings.Join(flags, ","), printable(entry.Value, 40))
+	}
+	return w.Flush()
+}
+
+func runRouting(ctx context.Context, c *client.Client, opts options, args []string) error {
+	routing, err := c.Routing(ctx)
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		return printJSON(routing)
+	}
+
+	fmt.Printf("Node %s (%s)\n", routing.ID, routing.Address)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BUCKET\tID\tADDRESS\tLAST SEEN\tFAILURES\tRTT")
+	row := func(bucket string, contact client.RoutingContact) {
+		lastSeen := "never"
+		if contact.LastSeen != nil {
+			lastSeen = time.Since(*contact.LastSeen).Round(time.Second).String() + " ago"
+		}
+		rtt := "-"
+		if contact.RTTMs > 0 {
+			rtt = fmt.Sprintf("%.1fms", contact.RTTMs)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", bucket, contact.ID, contact.Address, lastSeen, contact.Failures, rtt)
+	}
+	for _, bucket := range routing.Buckets {
+		for _, contact := range bucket.Contacts {
+			row(fmt.Sprint(bucket.Index), contact)
+		}
+		// replacements are listed under their bucket, marked with a +
+		for _, contact := range bucket.Replacements {
+			row(fmt.Sprintf("%d+", bucket.Index), contact)
+		}
+	}
+	return w.Flush()
+}
+
+func runStats(ctx context.Context, c *client.Client, opts options, args []string) error {
+	stats, err := c.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		return printJSON(stats)
+	}
+
+	fmt.Printf("Node %s (%s)\n", stats.ID, stats.Address)
+	fmt.Printf("Keys:       %d (%d origin, %d cached, %d deleted)\n", stats.Keys, stats.Origin, stats.Cached, stats.Tombstones)
+	fmt.Printf("Bytes:      %d\n", stats.Bytes)
+	fmt.Printf("Contacts:   %d in %d buckets\n", stats.Contacts, stats.Buckets)
+	return nil
+}
+
+func runShutdown(ctx context.Context, c *client.Client, opts options, args []string) error {
+	var failed []string
+	for _, endpoint := range c.Endpoints() {
+		if err := c.Shutdown(ctx, endpoint); err != nil {
+			endpoint = strings.TrimPrefix(endpoint, "http://")
+			fmt.Fprintf(os.Stderr, "kadctl: shutting down %s: %s\n", endpoint, err)
+			failed = append(failed, endpoint)
+			continue
+		}
+		if !opts.json {
+			fmt.Printf("Shut down %s\n", strings.TrimPrefix(endpoint, "http://"))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d nodes did not shut down", len(failed), len(c.Endpoints()))
+	}
+	return nil
+}
@@ -2,65 +2,100 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"github.com/peterdelong/kademlia"
-	"io"
 	"log"
-	"math/rand"
 	"os"
-	"time"
+	"strings"
 )
 
-// path to bootstrap_nodes
-
-func checkIOError(e error) {
-	if e != nil && e != io.EOF {
-		log.Fatal(e)
+// readSeeds returns the seeds listed in the file at path, one per line.
+// Blank lines and lines starting with # are skipped.
+func readSeeds(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	seeds := make([]string, 0, 100)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			seeds = append(seeds, line)
+		}
 	}
+	return seeds, scanner.Err()
 }
 
-// usage: kademlia_node <node_addr> [bootstrap_addr]
+// usage: kademlia_node [flags] <node_addr> <b/nb> [bootstrap_addr]
 func main() {
+	config := kademlia.DefaultConfig()
+	flag.DurationVar(&config.MaxTTL, "max-ttl", config.MaxTTL, "longest TTL a publisher can ask for")
+	flag.IntVar(&config.MaxValueSize, "max-value-size", config.MaxValueSize, "largest value in bytes that will be stored")
+	flag.Int64Var(&config.MaxStoreBytes, "max-store-bytes", config.MaxStoreBytes, "total bytes of values that will be held")
+	flag.IntVar(&config.MaxKeysPerPublisher, "max-keys-per-publisher", config.MaxKeysPerPublisher, "most keys held for any one publisher")
+	flag.IntVar(&config.ErasureShards, "erasure-n", config.ErasureShards, "shards stored for erasure coded values")
+	flag.IntVar(&config.ErasureDataShards, "erasure-m", config.ErasureDataShards, "shards needed to rebuild erasure coded values")
+	flag.StringVar(&config.LogLevel, "log-level", config.LogLevel, "levels to log at, such as \"info\" or \"warn,routing=debug\" (subsystems: routing, store, rpc, rest)")
+	flag.BoolVar(&config.LogJSON, "log-json", config.LogJSON, "log as JSON rather than text")
+	flag.BoolVar(&config.TraceLookups, "trace-lookups", config.TraceLookups, "keep a trace of every lookup on /traces, not just those asked for with ?trace=1")
+	flag.IntVar(&config.MinContacts, "min-contacts", config.MinContacts, "contacts the routing table must hold after joining for /readyz to report ready")
+	flag.IntVar(&config.JoinAttempts, "join-attempts", config.JoinAttempts, "attempts to join the network before exiting, 0 to keep retrying in the background")
+	flag.DurationVar(&config.JoinBackoff, "join-backoff", config.JoinBackoff, "wait after the first failed attempt to join, doubling after each")
+	seedList := flag.String("seeds", "", "comma separated host:port seeds to join through. A host name with several addresses, such as a DNS name, gives a seed for each")
+	seedFile := flag.String("seed-file", "", "file listing seeds to join through, one per line (default "+kademlia.Bootstrap_node_path+" if no other seeds are given)")
+	flag.Parse()
+
 	fmt.Println("Started")
-	s1 := rand.NewSource(time.Now().UnixNano())
-	r1 := rand.New(s1)
 
-	args := os.Args[1:]
+	args := flag.Args()
 
 	if len(args) < 2 {
-		fmt.Println("usage: kademlia_node <node_addr> <b/nb> [bootstrap_addr]")
+		fmt.Println("usage: kademlia_node [flags] <node_addr> <b/nb> [bootstrap_addr]")
+		flag.PrintDefaults()
 		return
 	}
 
 	addr := args[0]
-	nodes := make([]string, 0, 100)
 
-	// if this isn't a bootstrap node, read from a list of nodes in the system
-	bootstrapAddr := ""
+	// if this isn't a bootstrap node, join through every seed we're given,
+	// or the nodes in the bootstrap file if there are none
+	var seeds []string
 	if args[1] == "nb" {
-		num := r1.Intn(7000)
-		time.Sleep(time.Duration(num) * time.Millisecond)
-		if len(args) < 3 {
-			file, err := os.Open(kademlia.Bootstrap_node_path)
-			checkIOError(err)
-			reader := bufio.NewReader(file)
-			for line, err := reader.ReadString('\n'); err == nil; line, err = reader.ReadString('\n') {
-				// need to cut off the delimiter
-				address := line[0:len(line)-1]
-				nodes = append(nodes, address)
+		if len(args) >= 3 {
+			seeds = append(seeds, args[2])
+		}
+		for _, seed := range strings.Split(*seedList, ",") {
+			if seed = strings.TrimSpace(seed); seed != "" {
+				seeds = append(seeds, seed)
 			}
-		} else {
-			bootstrapAddr = args[2]
 		}
-		index := r1.Intn(len(nodes))
-		bootstrapAddr = nodes[index]
+		path := *seedFile
+		if path == "" && len(seeds) == 0 {
+			path = kademlia.Bootstrap_node_path
+		}
+		if path != "" {
+			fileSeeds, err := readSeeds(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			seeds = append(seeds, fileSeeds...)
+		}
+		if len(seeds) == 0 {
+			log.Fatalf("No seeds to join the network through")
+		}
 
-		fmt.Println("Contacting ", bootstrapAddr)
+		fmt.Printf("Joining through %d seeds\n", len(seeds))
 	}
 
-	node := kademlia.NewNode(addr)
+	node := kademlia.NewNodeWithConfig(addr, config)
+	if node == nil {
+		os.Exit(1)
+	}
 
 	fmt.Println(node)
 
-	node.Run(bootstrapAddr)
+	node.RunWithSeeds(seeds)
 }
@@ -0,0 +1,85 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Config holds the policy a node applies to the values it stores
+type Config struct {
+	// MaxTTL caps the lifetime a publisher can ask for
+	MaxTTL time.Duration `json:"max_ttl"`
+	// MaxValueSize is the largest value in bytes that will be stored
+	MaxValueSize int `json:"max_value_size"`
+	// MaxStoreBytes is the total size of values that will be held
+	MaxStoreBytes int64 `json:"max_store_bytes"`
+	// MaxKeysPerPublisher is the most keys held for any one publisher: a REST
+	// client for the values it publishes through this node, and otherwise
+	// the node that sent the STORE, including replicas and cached copies
+	MaxKeysPerPublisher int `json:"max_keys_per_publisher"`
+	// ErasureShards and ErasureDataShards are the default (n, m) for erasure
+	// coded values: n shards are stored and any m of them rebuild the value
+	ErasureShards     int `json:"erasure_shards"`
+	ErasureDataShards int `json:"erasure_data_shards"`
+	// DisableCaching stops lookups caching the values they find on the
+	// closest node that didn't have it
+	DisableCaching bool `json:"disable_caching"`
+	// LogLevel is a comma-separated list of levels to log at, either for
+	// every subsystem ("info") or for one ("routing=debug"). The subsystems
+	// are routing, store, rpc and rest.
+	LogLevel string `json:"log_level"`
+	// LogJSON writes log records as JSON rather than text
+	LogJSON bool `json:"log_json"`
+	// TraceLookups records a trace of every iterative lookup, not just the
+	// ones asked for with ?trace=1, in the ring buffer served on /traces
+	TraceLookups bool `json:"trace_lookups"`
+	// MinContacts is how many contacts the routing table must hold, once
+	// the node has joined, for it to be ready (see /readyz). A node that
+	// starts a new network is ready without any. JoinSeeds keeps trying
+	// until it has this many.
+	MinContacts int `json:"min_contacts"`
+	// JoinAttempts is how many times JoinSeeds tries to join before giving
+	// up, or 0 to keep trying
+	JoinAttempts int `json:"join_attempts"`
+	// JoinBackoff is the wait after the first failed attempt to join. It
+	// doubles after each one, up to a minute.
+	JoinBackoff time.Duration `json:"join_backoff"`
+}
+
+// DefaultConfig returns the configuration used by NewNode
+func DefaultConfig() Config {
+	return Config{
+		MaxTTL:              tExpire,
+		MaxValueSize:        1 << 20,
+		MaxStoreBytes:       256 << 20,
+		MaxKeysPerPublisher: 100000,
+		ErasureShards:       6,
+		ErasureDataShards:   4,
+		LogLevel:            "info",
+		MinContacts:         1,
+		JoinBackoff:         time.Second,
+	}
+}
+
+// MarshalJSON encodes config as it is reported on /info, with durations
+// written as strings such as "240h0m0s"
+func (config Config) MarshalJSON() ([]byte, error) {
+	type plain Config
+	return json.Marshal(struct {
+		plain
+		MaxTTL      string `json:"max_ttl"`
+		JoinBackoff string `json:"join_backoff"`
+	}{plain(config), config.MaxTTL.String(), config.JoinBackoff.String()})
+}
+
+// capTTL returns the lifetime a value stored with ttl will be kept for
+// Zero means the default of tExpire
+func (config *Config) capTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		ttl = tExpire
+	}
+	if config.MaxTTL > 0 && ttl > config.MaxTTL {
+		ttl = config.MaxTTL
+	}
+	return ttl
+}
@@ -0,0 +1,240 @@
+package kademlia
+
+import (
+	"errors"
+	"fmt"
+)
+
+// This file contains a Reed-Solomon erasure code over GF(2^8). A value is split
+// into m data shards and extended with n-m parity shards, and any m of the n
+// shards are enough to get the value back.
+
+// gfExp and gfLog are exponent and logarithm tables for GF(2^8) with the
+// generator polynomial x^8 + x^4 + x^3 + x^2 + 1
+var gfExp [510]byte
+var gfLog [256]int
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a byte, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-gfLog[a]]
+}
+
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(gfLog[a]*n)%255]
+}
+
+// gfInvert returns the inverse of the square matrix m by Gauss-Jordan
+// elimination
+func gfInvert(m [][]byte) ([][]byte, error) {
+	size := len(m)
+	work := make([][]byte, size)
+	for i := range m {
+		work[i] = make([]byte, 2*size)
+		copy(work[i], m[i])
+		work[i][size+i] = 1
+	}
+
+	for col := 0; col < size; col++ {
+		pivot := col
+		for pivot < size && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == size {
+			return nil, errors.New("matrix is singular")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		scale := gfInv(work[col][col])
+		for c := range work[col] {
+			work[col][c] = gfMul(work[col][c], scale)
+		}
+		for row := 0; row < size; row++ {
+			if row == col || work[row][col] == 0 {
+				continue
+			}
+			factor := work[row][col]
+			for c := range work[row] {
+				work[row][c] ^= gfMul(factor, work[col][c])
+			}
+		}
+	}
+
+	inverse := make([][]byte, size)
+	for i := range work {
+		inverse[i] = work[i][size:]
+	}
+	return inverse, nil
+}
+
+// reedSolomon encodes values into totalShards shards, any dataShards of which
+// can rebuild the value
+type reedSolomon struct {
+	dataShards  int
+	totalShards int
+	// matrix is the totalShards x dataShards encoding matrix. Its top rows
+	// are the identity, so the data shards are the value itself.
+	matrix [][]byte
+}
+
+// newReedSolomon returns a code with n shards in total, m of which are data
+func newReedSolomon(n int, m int) (*reedSolomon, error) {
+	if m <= 0 || n < m || n > 256 {
+		return nil, fmt.Errorf("invalid erasure code (%d, %d)", n, m)
+	}
+
+	// any m rows of a Vandermonde matrix are independent, and stay so when
+	// it is multiplied by the inverse of its top square
+	vandermonde := make([][]byte, n)
+	for r := range vandermonde {
+		vandermonde[r] = make([]byte, m)
+		for c := range vandermonde[r] {
+			vandermonde[r][c] = gfPow(byte(r), c)
+		}
+	}
+	topInverse, err := gfInvert(vandermonde[:m])
+	if err != nil {
+		return nil, err
+	}
+
+	rs := &reedSolomon{dataShards: m, totalShards: n, matrix: make([][]byte, n)}
+	for r := range vandermonde {
+		rs.matrix[r] = make([]byte, m)
+		for c := 0; c < m; c++ {
+			var sum byte
+			for i := 0; i < m; i++ {
+				sum ^= gfMul(vandermonde[r][i], topInverse[i][c])
+			}
+			rs.matrix[r][c] = sum
+		}
+	}
+	return rs, nil
+}
+
+// encode splits val into data shards, padding the last with zeros, and returns
+// them followed by the parity shards
+func (rs *reedSolomon) encode(val []byte) [][]byte {
+	shardSize := (len(val) + rs.dataShards - 1) / rs.dataShards
+	if shardSize == 0 {
+		shardSize = 1
+	}
+	padded := make([]byte, shardSize*rs.dataShards)
+	copy(padded, val)
+
+	shards := make([][]byte, rs.totalShards)
+	for i := 0; i < rs.dataShards; i++ {
+		shards[i] = padded[i*shardSize : (i+1)*shardSize]
+	}
+	for i := rs.dataShards; i < rs.totalShards; i++ {
+		shards[i] = rs.combine(rs.matrix[i], shards[:rs.dataShards], shardSize)
+	}
+	return shards
+}
+
+// combine returns the sum of inputs weighted by coefficients
+func (rs *reedSolomon) combine(coefficients []byte, inputs [][]byte, shardSize int) []byte {
+	out := make([]byte, shardSize)
+	for i, input := range inputs {
+		coefficient := coefficients[i]
+		if coefficient == 0 {
+			continue
+		}
+		for b := range out {
+			out[b] ^= gfMul(coefficient, input[b])
+		}
+	}
+	return out
+}
+
+// reconstruct fills in the nil entries of shards from the others. At least
+// dataShards of them must be present and the same size.
+func (rs *reedSolomon) reconstruct(shards [][]byte) error {
+	if len(shards) != rs.totalShards {
+		return fmt.Errorf("expected %d shards, got %d", rs.totalShards, len(shards))
+	}
+
+	rows := make([][]byte, 0, rs.dataShards)
+	present := make([][]byte, 0, rs.dataShards)
+	shardSize := 0
+	for i, shard := range shards {
+		if shard == nil || len(rows) == rs.dataShards {
+			continue
+		}
+		if shardSize != 0 && len(shard) != shardSize {
+			return errors.New("shards are different sizes")
+		}
+		shardSize = len(shard)
+		rows = append(rows, rs.matrix[i])
+		present = append(present, shard)
+	}
+	if len(rows) < rs.dataShards {
+		return fmt.Errorf("need %d shards to reconstruct, have %d", rs.dataShards, len(rows))
+	}
+
+	decode, err := gfInvert(rows)
+	if err != nil {
+		return err
+	}
+	data := make([][]byte, rs.dataShards)
+	for i := range data {
+		if shards[i] != nil {
+			data[i] = shards[i]
+		} else {
+			data[i] = rs.combine(decode[i], present, shardSize)
+		}
+	}
+	for i := range shards {
+		if shards[i] != nil {
+			continue
+		}
+		if i < rs.dataShards {
+			shards[i] = data[i]
+		} else {
+			shards[i] = rs.combine(rs.matrix[i], data, shardSize)
+		}
+	}
+	return nil
+}
+
+// join concatenates the data shards and strips the padding from a value of
+// the given size
+func (rs *reedSolomon) join(shards [][]byte, size int64) ([]byte, error) {
+	total := int64(0)
+	for _, shard := range shards[:rs.dataShards] {
+		total += int64(len(shard))
+	}
+	if size < 0 || total < size {
+		return nil, errors.New("shards are too short")
+	}
+	val := make([]byte, 0, total)
+	for _, shard := range shards[:rs.dataShards] {
+		val = append(val, shard...)
+	}
+	return val[:size], nil
+}
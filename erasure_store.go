@@ -0,0 +1,220 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErasureManifest describes a value stored as Reed-Solomon shards. Like the
+// chunks of a large value, the shards are immutable values and the manifest
+// is stored under its own content key.
+type ErasureManifest struct {
+	Size       int64    `json:"size"`
+	DataShards int      `json:"data_shards"`
+	ShardKeys  []string `json:"shards"`
+}
+
+// StoreErasure erasure codes val into n shards, any m of which can rebuild
+// it, and stores each shard on a different node. Zero n and m use the node's
+// Config. Returns the key of the manifest.
+func (node *Node) StoreErasure(val []byte, n int, m int, ttl time.Duration) (string, error) {
+	return node.storeErasure(val, n, m, StoreArgs{Source: node.addr, Published: time.Now(), TTL: ttl})
+}
+
+// storeErasure is StoreErasure with the rest of the STORE arguments for each
+// shard and the manifest taken from template
+func (node *Node) storeErasure(val []byte, n int, m int, template StoreArgs) (string, error) {
+	if n == 0 && m == 0 {
+		n = node.config.ErasureShards
+		m = node.config.ErasureDataShards
+	}
+	rs, err := newReedSolomon(n, m)
+	if err != nil {
+		return "", err
+	}
+
+	shards := rs.encode(val)
+	if node.config.MaxValueSize > 0 && len(shards[0]) > node.config.MaxValueSize {
+		return "", fmt.Errorf("shards would be %d bytes, the limit is %d", len(shards[0]), node.config.MaxValueSize)
+	}
+
+	manifest := ErasureManifest{Size: int64(len(val)), DataShards: m, ShardKeys: make([]string, n)}
+	used := make(map[string]bool)
+	for i, shard := range shards {
+		args := template
+		args.Key = ContentKey(shard)
+		args.Val = shard
+		args.Immutable = true
+		manifest.ShardKeys[i] = args.Key
+		if err := node.placeShard(args, used); err != nil {
+			return "", fmt.Errorf("storing shard %d: %s", i, err)
+		}
+	}
+
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	args := template
+	args.Key = ContentKey(encoded)
+	args.Val = encoded
+	args.Immutable = true
+	args.Erasure = true
+	if err := node.publish(args); err != nil {
+		return "", fmt.Errorf("storing manifest: %s", err)
+	}
+
+	node.logs.store.Info("Stored erasure coded value", "key", args.Key, "bytes", len(val), "shards", n, "data_shards", m)
+	return args.Key, nil
+}
+
+// placeShard stores a shard on the node closest to its key that isn't already
+// holding another shard of the same value, and adds that node to used. If
+// every candidate is in use the closest one is reused.
+func (node *Node) placeShard(args StoreArgs, used map[string]bool) error {
+	toFindID := keyID(args.Key)
+	candidates := append(node.doIterativeFindNode(args.Key), *NewContact(node.addr))
+	candidates = RemoveDupesFromShortlist(candidates)
+	sort.Slice(candidates, func(i, j int) bool {
+		return closerTo(toFindID, candidates[i].Id, candidates[j].Id)
+	})
+
+	var err error
+	for _, contact := range candidates {
+		if used[contact.Addr.String()] {
+			continue
+		}
+		if err = node.sendStore(args, contact.Addr); err == nil {
+			used[contact.Addr.String()] = true
+			return nil
+		}
+	}
+	for _, contact := range candidates {
+		if err = node.sendStore(args, contact.Addr); err == nil {
+			return nil
+		}
+	}
+	if err == nil {
+		err = errors.New("no nodes to store on")
+	}
+	return err
+}
+
+// FetchErasure looks up the erasure coded value with the given manifest key,
+// rebuilding it from whichever shards can be found
+func (node *Node) FetchErasure(key string) ([]byte, error) {
+	manifest, err := node.fetchErasureManifest(key)
+	if err != nil {
+		return nil, err
+	}
+	rs, shards, err := node.fetchShards(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := rs.reconstruct(shards); err != nil {
+		return nil, err
+	}
+	return rs.join(shards, manifest.Size)
+}
+
+// fetchErasureManifest looks up and decodes the manifest stored under key
+func (node *Node) fetchErasureManifest(key string) (*ErasureManifest, error) {
+	encoded := node.doIterativeFindValue(key, true)
+	if encoded == nil {
+		return nil, fmt.Errorf("manifest %s not found", key)
+	}
+	return decodeErasureManifest(encoded, node.config.MaxValueSize)
+}
+
+// decodeErasureManifest decodes a manifest, checking that its data shards,
+// each at most maxShardSize bytes (0 for no limit), can hold the value
+func decodeErasureManifest(encoded []byte, maxShardSize int) (*ErasureManifest, error) {
+	manifest := new(ErasureManifest)
+	if err := json.Unmarshal(encoded, manifest); err != nil {
+		return nil, fmt.Errorf("not an erasure manifest: %s", err)
+	}
+	if manifest.Size < 0 || manifest.DataShards <= 0 || len(manifest.ShardKeys) < manifest.DataShards {
+		return nil, errors.New("erasure manifest is inconsistent")
+	}
+	if maxShardSize > 0 && manifest.Size > int64(manifest.DataShards)*int64(maxShardSize) {
+		return nil, fmt.Errorf("erasure manifest is for %d bytes, more than %d shards of %d bytes hold", manifest.Size, manifest.DataShards, maxShardSize)
+	}
+	return manifest, nil
+}
+
+// fetchShards looks up every shard in manifest in parallel, leaving nil
+// entries for the ones that couldn't be found
+func (node *Node) fetchShards(manifest *ErasureManifest) (*reedSolomon, [][]byte, error) {
+	rs, err := newReedSolomon(len(manifest.ShardKeys), manifest.DataShards)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type result struct {
+		index int
+		shard []byte
+	}
+	resultChan := make(chan result)
+	for i, shardKey := range manifest.ShardKeys {
+		go func(i int, shardKey string) {
+			resultChan <- result{i, node.doIterativeFindValue(shardKey, true)}
+		}(i, shardKey)
+	}
+
+	shards := make([][]byte, len(manifest.ShardKeys))
+	for range manifest.ShardKeys {
+		res := <-resultChan
+		shards[res.index] = res.shard
+	}
+	return rs, shards, nil
+}
+
+// repairErasure checks that every shard of the erasure coded value whose
+// manifest is kv can be found, and rebuilds and re-stores any that can't
+func (node *Node) repairErasure(kv *KV) {
+	key := kv.key
+	manifest, err := decodeErasureManifest(kv.val, node.config.MaxValueSize)
+	if err != nil {
+		node.logs.store.Warn("Can't repair erasure coded value", "key", key, "err", err)
+		return
+	}
+	rs, shards, err := node.fetchShards(manifest)
+	if err != nil {
+		node.logs.store.Warn("Can't repair erasure coded value", "key", key, "err", err)
+		return
+	}
+
+	missing := make([]int, 0)
+	for i, shard := range shards {
+		if shard == nil {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	node.logs.store.Info("Erasure coded value is missing shards", "key", key, "missing", len(missing))
+	if err := rs.reconstruct(shards); err != nil {
+		node.logs.store.Warn("Can't repair erasure coded value", "key", key, "err", err)
+		return
+	}
+
+	used := make(map[string]bool)
+	for _, i := range missing {
+		args := StoreArgs{
+			Source:    node.addr,
+			Key:       manifest.ShardKeys[i],
+			Val:       shards[i],
+			Immutable: true,
+			Published: time.Now(),
+			TTL:       kv.ttl(),
+			Publisher: kv.publisher,
+		}
+		if err := node.placeShard(args, used); err != nil {
+			node.logs.store.Warn("Failed to repair shard", "key", key, "shard", i, "err", err)
+		}
+	}
+}
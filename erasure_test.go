@@ -0,0 +1,61 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchErasureChecksManifest(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	shard := []byte("shard")
+	shardKey := ContentKey(shard)
+	node.ht.add(shardKey, shard, true)
+
+	tests := []struct {
+		manifest ErasureManifest
+		ok       bool
+	}{
+		{ErasureManifest{Size: 5, DataShards: 1, ShardKeys: []string{shardKey}}, true},
+		{ErasureManifest{Size: 3, DataShards: 1, ShardKeys: []string{shardKey, shardKey}}, true},
+		{ErasureManifest{Size: -1, DataShards: 1, ShardKeys: []string{shardKey}}, false},
+		{ErasureManifest{Size: 1 << 62, DataShards: 1, ShardKeys: []string{shardKey}}, false},
+		{ErasureManifest{Size: 6, DataShards: 1, ShardKeys: []string{shardKey}}, false},
+		{ErasureManifest{Size: 5, DataShards: 2, ShardKeys: []string{shardKey}}, false},
+		{ErasureManifest{Size: 0, DataShards: 0, ShardKeys: nil}, false},
+	}
+	for _, test := range tests {
+		encoded, _ := json.Marshal(test.manifest)
+		key := ContentKey(encoded)
+		node.ht.add(key, encoded, true)
+		val, err := node.FetchErasure(key)
+		if (err == nil) != test.ok {
+			t.Errorf("fetching %s returned %v", encoded, err)
+		} else if test.ok && string(val) != "shard"[:test.manifest.Size] {
+			t.Errorf("fetching %s returned %q", encoded, val)
+		}
+	}
+
+	if _, err := decodeErasureManifest([]byte(`{"size":11,"data_shards":2,"shards":["a","b"]}`), 5); err == nil {
+		t.Error("manifest larger than its shards can hold was accepted")
+	}
+}
+
+func TestStoreErasureLimit(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	node.config.MaxValueSize = 4
+	post := func(query string, body string) int {
+		w := httptest.NewRecorder()
+		node.handleStoreErasure(w, httptest.NewRequest("POST", "/erasure?"+query, strings.NewReader(body)))
+		return w.Code
+	}
+	// two data shards of at most 4 bytes each
+	if code := post("n=3&m=2", "123456789"); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST of a value too large for its shards returned %d", code)
+	}
+	if code := post("n=300&m=2", "1"); code != http.StatusBadRequest {
+		t.Errorf("POST with too many shards returned %d", code)
+	}
+}
@@ -17,6 +17,11 @@ const tReplicate = 3600 * time.Second
 // key/value pair
 const tRepublish = 86400 * time.Second
 
+// staleLimit is the number of RPCs in a row a contact can fail to answer
+// before it is dropped from the routing table when there is no replacement
+// waiting for its place (section 4.1)
+const staleLimit = 5
+
 // Alpha is the degree of parallelism in network calls
 const alpha = 3
 
@@ -29,7 +34,35 @@ const keyBase = 16
 // turn caching on and off
 const caching_on = true
 
-// Turn logging on and off
-const loggingEnable = true
+// Use SHA-256 (truncated to the 160-bit key space) rather than SHA-1 for
+// content-addressed keys
+const contentHashSHA256 = false
 
 const Bootstrap_node_path = "/home/pdelong/go/src/github.com/peterdelong/kademlia/cmd/kademlia_node/bootstrap_nodes"
+
+// chunkSize is the size of the pieces large values are split into
+const chunkSize = 256 * 1024
+
+// chunkParallelism is the number of chunks of a large value stored or fetched
+// at once
+const chunkParallelism = 4
+
+// traceBufferSize is the number of lookup traces a node keeps (see /traces)
+const traceBufferSize = 64
+
+// tombstoneSize is the number of bytes a tombstone is counted as taking in the
+// store, on top of its key and secret
+const tombstoneSize = 64
+
+// joinSeeds is the number of seeds pinged at once while joining
+const joinSeeds = 3
+
+// maxJoinBackoff is the longest wait between attempts to join the network
+const maxJoinBackoff = time.Minute
+
+// lookupTimeout is how long the REST API waits for an iterative lookup
+const lookupTimeout = 30 * time.Second
+
+// Version is reported on /info. Release builds set it with
+// -ldflags "-X github.com/peterdelong/kademlia.Version=<version>".
+var Version = "dev"
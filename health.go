@@ -0,0 +1,97 @@
+package kademlia
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// readyJSON is the /readyz response
+type readyJSON struct {
+	Ready bool `json:"ready"`
+	// Reason says why the node isn't ready, or how it became ready
+	Reason string `json:"reason"`
+	// Contacts is the number of contacts we've heard from, which is what
+	// MinContacts applies to
+	Contacts    int `json:"contacts"`
+	MinContacts int `json:"min_contacts"`
+}
+
+// infoJSON is the /info response
+type infoJSON struct {
+	ID      string    `json:"id"`
+	Address string    `json:"address"`
+	Version string    `json:"version"`
+	Started time.Time `json:"started"`
+	// UptimeSeconds is how long ago the node was started
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Ready         bool    `json:"ready"`
+	Keys          int     `json:"keys"`
+	Bytes         int64   `json:"bytes"`
+	Contacts      int     `json:"contacts"`
+	Config        Config  `json:"config"`
+}
+
+// readiness reports whether the node is ready to serve requests: it has
+// joined a network and heard from at least config.MinContacts contacts, or it
+// has started a network of its own
+func (node *Node) readiness() readyJSON {
+	ready := readyJSON{Contacts: node.rt.seenContacts(), MinContacts: node.config.MinContacts}
+	switch atomic.LoadInt32(&node.joinState) {
+	case joining:
+		ready.Reason = "joining the network"
+	case startedNetwork:
+		ready.Ready = true
+		ready.Reason = "started a new network"
+	case joined:
+		if ready.Contacts < ready.MinContacts {
+			ready.Reason = fmt.Sprintf("heard from %d of %d contacts", ready.Contacts, ready.MinContacts)
+		} else {
+			ready.Ready = true
+			ready.Reason = "joined the network"
+		}
+	}
+	return ready
+}
+
+// handleHealthz answers whenever the node is serving, so it is alive even if
+// it isn't ready
+func (node *Node) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"GET", "HEAD"}, r, w) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz responds with 200 once the node is ready (see readiness) and
+// 503 until then
+func (node *Node) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"GET", "HEAD"}, r, w) {
+		return
+	}
+	ready := node.readiness()
+	status := http.StatusOK
+	if !ready.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, ready)
+}
+
+func (node *Node) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"GET"}, r, w) {
+		return
+	}
+	info := infoJSON{
+		ID:            node.id.String(),
+		Address:       node.addr.String(),
+		Version:       Version,
+		Started:       node.started,
+		UptimeSeconds: time.Since(node.started).Seconds(),
+		Ready:         node.readiness().Ready,
+		Contacts:      len(node.rt.contacts()),
+		Config:        node.config,
+	}
+	info.Keys, info.Bytes = node.ht.size()
+	writeJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,82 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	readyz := func(node *Node) (int, readyJSON) {
+		w := httptest.NewRecorder()
+		node.handleReadyz(w, httptest.NewRequest("GET", "/readyz", nil))
+		var ready readyJSON
+		json.Unmarshal(w.Body.Bytes(), &ready)
+		return w.Code, ready
+	}
+
+	node := newTestNode(t, "10.1.0.1:4000")
+	if code, ready := readyz(node); code != http.StatusServiceUnavailable || ready.Ready {
+		t.Errorf("node that hasn't joined returned %d %+v", code, ready)
+	}
+	w := httptest.NewRecorder()
+	node.handleHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /healthz before joining returned %d", w.Code)
+	}
+
+	// the first node of a network has no one to know
+	node.Join("")
+	if code, ready := readyz(node); code != http.StatusOK || !ready.Ready || ready.Contacts != 0 {
+		t.Errorf("node that started a network returned %d %+v", code, ready)
+	}
+
+	// nobody answers the join, so the node we joined through doesn't count
+	node = newTestNode(t, "10.1.0.1:4000")
+	seed := testContact(1)
+	node.Join(seed.Addr.String())
+	if code, ready := readyz(node); code != http.StatusServiceUnavailable || ready.Contacts != 0 || ready.Reason != "joining the network" {
+		t.Errorf("node whose join wasn't answered returned %d %+v", code, ready)
+	}
+
+	// the join is answered by the seed and the node it knows, but nobody
+	// answers at the third address they know
+	nodes, _ := chain(t, 2)
+	node = joiner(t, nodes[0].transport, 3, 1)
+	node.Join(nodes[0].addr.String())
+	if code, ready := readyz(node); code != http.StatusServiceUnavailable || ready.Contacts != 2 || ready.MinContacts != 3 {
+		t.Errorf("node short of contacts returned %d %+v", code, ready)
+	}
+	contact := testContact(5)
+	node.rt.add(contact)
+	node.rt.seen(contact, 0)
+	if code, ready := readyz(node); code != http.StatusOK || ready.Contacts != 3 {
+		t.Errorf("node with enough contacts returned %d %+v", code, ready)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	node.ht.add(ContentKey([]byte("value")), []byte("value"), true)
+	node.Join("")
+
+	w := httptest.NewRecorder()
+	node.handleInfo(w, httptest.NewRequest("GET", "/info", nil))
+	var info struct {
+		infoJSON
+		Config map[string]interface{} `json:"config"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /info returned %d %s", w.Code, w.Body.String())
+	}
+	if info.ID != node.id.String() || info.Address != "10.1.0.1:4000" || info.Version != Version {
+		t.Errorf("GET /info returned node %s at %s, version %s", info.ID, info.Address, info.Version)
+	}
+	if info.Keys != 1 || info.Bytes != 5 || !info.Ready || info.UptimeSeconds < 0 {
+		t.Errorf("GET /info returned %+v", info.infoJSON)
+	}
+	if info.Config["max_ttl"] != tExpire.String() || info.Config["min_contacts"] != 1.0 {
+		t.Errorf("GET /info returned config %v", info.Config)
+	}
+}
@@ -1,36 +1,181 @@
 package kademlia
 
-import ()
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
 
 // KVStore holds mappings from keys to values and keeps track if a given node is
 // the owner of the value
 type KVStore struct {
 	//owner    *Node
-	ht       map[string][]byte
-	isOrigin map[string]bool
+	ht map[string]*KV
+	mu *sync.Mutex
+
+	// bytes is the total size of the values held
+	bytes int64
+	// publisherKeys counts the keys held for each publisher
+	publisherKeys map[string]int
 }
 
 // NewKVStore returns a newly initialized KVStore
 func NewKVStore() *KVStore {
 	kvStore := new(KVStore)
-	kvStore.ht = make(map[string][]byte)
-	kvStore.isOrigin = make(map[string]bool)
+	kvStore.ht = make(map[string]*KV)
+	kvStore.mu = &sync.Mutex{}
+	kvStore.publisherKeys = make(map[string]int)
 
 	//kvStore.owner = owner
 	return kvStore
 }
 
+// get returns the value stored for key. Deleted and expired keys are not
+// found.
 func (store *KVStore) get(key string) ([]byte, bool) {
-	if val, ok := store.ht[key]; ok {
-		return val, true
+	if kv, ok := store.getKV(key); ok && !kv.tombstone && !kv.expired() {
+		return kv.val, true
 	}
 	return nil, false
 }
 
+// getKV returns a copy of everything we have stored for key
+func (store *KVStore) getKV(key string) (*KV, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if kv, ok := store.ht[key]; ok {
+		kvCopy := *kv
+		return &kvCopy, true
+	}
+	return nil, false
+}
+
+// size returns the number of keys held, including tombstones, and the total
+// space they are counted as taking (see KV.size)
+func (store *KVStore) size() (int, int64) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return len(store.ht), store.bytes
+}
+
 // Will overwrite existing value
 func (store *KVStore) add(key string, val []byte, isOrigin bool) {
-	store.ht[key] = val
-	store.isOrigin[key] = isOrigin
+	now := time.Now()
+	store.put(&KV{key: key, val: val, isOrigin: isOrigin, published: now, expires: now.Add(tExpire)})
+}
+
+// put stores kv under kv.key, overwriting any existing entry
+func (store *KVStore) put(kv *KV) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.account(store.ht[kv.key], kv)
+	store.ht[kv.key] = kv
+}
+
+// update atomically replaces the entry for key with the result of fn, which is
+// passed the existing entry (or nil). If fn returns an error the store is left
+// untouched.
+func (store *KVStore) update(key string, fn func(existing *KV) (*KV, error)) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	kv, err := fn(store.ht[key])
+	if err != nil {
+		return err
+	}
+	store.account(store.ht[key], kv)
+	store.ht[key] = kv
+	return nil
+}
+
+// removeIf removes the entry for key if fn returns true for it
+func (store *KVStore) removeIf(key string, fn func(kv *KV) bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if kv, ok := store.ht[key]; ok && fn(kv) {
+		store.account(kv, nil)
+		delete(store.ht, key)
+	}
+}
+
+// account updates the size and per-publisher totals for old being replaced by
+// new, either of which may be nil. Must be called with store.mu held.
+func (store *KVStore) account(old *KV, new *KV) {
+	if old != nil {
+		store.bytes -= old.size()
+		if old.publisher != "" {
+			store.publisherKeys[old.publisher]--
+			if store.publisherKeys[old.publisher] == 0 {
+				delete(store.publisherKeys, old.publisher)
+			}
+		}
+	}
+	if new != nil {
+		store.bytes += new.size()
+		if new.publisher != "" {
+			store.publisherKeys[new.publisher]++
+		}
+	}
+}
+
+// checkLimits returns an error if storing kv in place of existing would break
+// the limits in config. If the store would grow past config.MaxStoreBytes,
+// cached copies are evicted to make room, followed by replicas we hold for
+// other publishers if kv isn't itself a cached copy. Values we originated are
+// never evicted. Must be called with store.mu held, from an update callback.
+func (store *KVStore) checkLimits(config *Config, existing *KV, kv *KV) error {
+	if config.MaxValueSize > 0 && len(kv.val) > config.MaxValueSize {
+		return fmt.Errorf("value is %d bytes, the limit is %d", len(kv.val), config.MaxValueSize)
+	}
+
+	if config.MaxKeysPerPublisher > 0 && kv.publisher != "" {
+		alreadyCounted := existing != nil && existing.publisher == kv.publisher
+		if !alreadyCounted && store.publisherKeys[kv.publisher] >= config.MaxKeysPerPublisher {
+			return fmt.Errorf("publisher %s has reached its limit of %d keys", kv.publisher, config.MaxKeysPerPublisher)
+		}
+	}
+
+	if config.MaxStoreBytes <= 0 {
+		return nil
+	}
+	need := store.bytes + kv.size() - config.MaxStoreBytes
+	if existing != nil {
+		need -= existing.size()
+	}
+	if need <= 0 {
+		return nil
+	}
+
+	// cached copies go first, then replicas, soonest to expire first
+	candidates := make([]*KV, 0)
+	for _, other := range store.ht {
+		if other.key == kv.key || other.isOrigin || other.tombstone || len(other.val) == 0 {
+			continue
+		}
+		if other.cached || !kv.cached {
+			candidates = append(candidates, other)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].cached != candidates[j].cached {
+			return candidates[i].cached
+		}
+		return candidates[i].expires.Before(candidates[j].expires)
+	})
+
+	evict := 0
+	for freed := int64(0); freed < need; evict++ {
+		if evict == len(candidates) {
+			return errors.New("store is full")
+		}
+		freed += candidates[evict].size()
+	}
+	for _, other := range candidates[:evict] {
+		store.account(other, nil)
+		delete(store.ht, other.key)
+	}
+	return nil
 }
 
 // KV contains all the information we have for a key
@@ -38,20 +183,78 @@ type KV struct {
 	key      string
 	val      []byte
 	isOrigin bool
+	// immutable values are content-addressed: key is the hash of val
+	immutable bool
+	// mutable is set for values owned by a keypair (see MutableRecord)
+	mutable *MutableRecord
+	// published is when the original publisher stored the value
+	published time.Time
+	// ownerHash is the hash of the secret needed to delete the value
+	ownerHash []byte
+	// expires is when the value should be dropped
+	expires time.Time
+	// publisher is who the key is charged to under MaxKeysPerPublisher (see
+	// StoreArgs.Publisher), or for unverified tombstones the node that sent
+	// the delete
+	publisher string
+	// cached copies were stored by a lookup rather than the publisher, and are
+	// the first to go when the store is full
+	cached bool
+	// erasure is set if val is an ErasureManifest
+	erasure bool
+	// name is the human-readable name the key was derived from, if any
+	name string
+
+	// tombstones mark deleted keys so the value isn't brought back by
+	// replication or caching
+	tombstone bool
+	deleted   time.Time
+	// deleteSecret authorizes passing the tombstone on to other nodes
+	deleteSecret []byte
+	// unverified tombstones were sent to us before we had the value, so we
+	// couldn't check the secret. They only block values stored with the same
+	// owner hash.
+	unverified bool
 }
 
 // Iterator returns a channel that iterates over all the keys that we've stored
 func (store *KVStore) Iterator() chan *KV {
+	store.mu.Lock()
+	kvs := make([]*KV, 0, len(store.ht))
+	for _, v := range store.ht {
+		kv := *v
+		kvs = append(kvs, &kv)
+	}
+	store.mu.Unlock()
+
 	ch := make(chan *KV)
 	go func() {
-		for k, v := range store.ht {
-			kv := new(KV)
-			kv.key = k
-			kv.val = v
-			kv.isOrigin = store.isOrigin[k]
+		for _, kv := range kvs {
 			ch <- kv
 		}
 		close(ch)
 	}()
 	return ch
 }
+
+// size is the space kv is counted as taking in the store. Tombstones hold no
+// value, but are counted too so that deletes can't fill the store for free.
+func (kv *KV) size() int64 {
+	if kv.tombstone {
+		return tombstoneSize + int64(len(kv.key)+len(kv.deleteSecret))
+	}
+	return int64(len(kv.val))
+}
+
+// expired returns true if the value has outlived its TTL
+func (kv *KV) expired() bool {
+	return !kv.expires.IsZero() && !time.Now().Before(kv.expires)
+}
+
+// ttl returns how much longer the value has to live
+func (kv *KV) ttl() time.Duration {
+	if kv.expires.IsZero() {
+		return tExpire
+	}
+	return time.Until(kv.expires)
+}
@@ -0,0 +1,222 @@
+package kademlia
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// logSubsystems are the parts of the node that log, each at its own level
+var logSubsystems = []string{"routing", "store", "rpc", "rest"}
+
+// subsystemLog is the logger of one subsystem
+type subsystemLog struct {
+	logger *slog.Logger
+}
+
+// logf logs the formatted message at level, recording the caller of
+// Debugf, Infof, Warnf or Errorf as the source
+func (l subsystemLog) logf(level slog.Level, format string, args ...interface{}) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	record := slog.NewRecord(time.Now(), level, fmt.Sprintf(format, args...), pcs[0])
+	l.logger.Handler().Handle(ctx, record)
+}
+
+func (l subsystemLog) Debugf(format string, args ...interface{}) {
+	l.logf(slog.LevelDebug, format, args...)
+}
+
+func (l subsystemLog) Infof(format string, args ...interface{}) {
+	l.logf(slog.LevelInfo, format, args...)
+}
+
+func (l subsystemLog) Warnf(format string, args ...interface{}) {
+	l.logf(slog.LevelWarn, format, args...)
+}
+
+func (l subsystemLog) Errorf(format string, args ...interface{}) {
+	l.logf(slog.LevelError, format, args...)
+}
+
+// log logs msg at level with the key/value pairs in args, which are read the
+// way slog.Logger.Log reads them, recording the caller of Debug, Info, Warn or
+// Error as the source
+func (l subsystemLog) log(level slog.Level, msg string, args ...interface{}) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	record := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	record.Add(args...)
+	l.logger.Handler().Handle(ctx, record)
+}
+
+func (l subsystemLog) Debug(msg string, args ...interface{}) {
+	l.log(slog.LevelDebug, msg, args...)
+}
+
+func (l subsystemLog) Info(msg string, args ...interface{}) {
+	l.log(slog.LevelInfo, msg, args...)
+}
+
+func (l subsystemLog) Warn(msg string, args ...interface{}) {
+	l.log(slog.LevelWarn, msg, args...)
+}
+
+func (l subsystemLog) Error(msg string, args ...interface{}) {
+	l.log(slog.LevelError, msg, args...)
+}
+
+// levelHandler drops the records below the level of a subsystem
+type levelHandler struct {
+	level *slog.LevelVar
+	slog.Handler
+}
+
+func (h levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.Handler.Enabled(ctx, level)
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{h.level, h.Handler.WithAttrs(attrs)}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{h.level, h.Handler.WithGroup(name)}
+}
+
+// logs holds the loggers of a node, which write through the same handler but
+// have their own levels
+type logs struct {
+	levels map[string]*slog.LevelVar
+
+	routing subsystemLog
+	store   subsystemLog
+	rpc     subsystemLog
+	rest    subsystemLog
+}
+
+// newLogs returns loggers for the node at addr that write to w, as JSON if
+// json is set, at the levels in spec (see setLevels)
+func newLogs(w io.Writer, json bool, spec string, addr string) (*logs, error) {
+	l := &logs{levels: make(map[string]*slog.LevelVar)}
+	for _, subsystem := range logSubsystems {
+		l.levels[subsystem] = new(slog.LevelVar)
+	}
+	if err := l.setLevels(spec); err != nil {
+		return nil, err
+	}
+	l.setOutput(w, json, addr)
+	return l, nil
+}
+
+// setOutput points every logger at w
+func (l *logs) setOutput(w io.Writer, json bool, addr string) {
+	options := &slog.HandlerOptions{AddSource: true, Level: slog.LevelDebug}
+	var handler slog.Handler
+	if json {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	handler = handler.WithAttrs([]slog.Attr{slog.String("node", addr)})
+	logger := func(subsystem string) subsystemLog {
+		h := levelHandler{l.levels[subsystem], handler}
+		return subsystemLog{slog.New(h).With("subsystem", subsystem)}
+	}
+	l.routing = logger("routing")
+	l.store = logger("store")
+	l.rpc = logger("rpc")
+	l.rest = logger("rest")
+}
+
+// setLevels applies spec, a comma-separated list of levels ("debug", "info",
+// "warn" or "error"). A bare level applies to every subsystem and
+// subsystem=level to one, so "warn,routing=debug" quietens everything except
+// routing. Nothing is changed if spec isn't valid. The empty spec is "info".
+func (l *logs) setLevels(spec string) error {
+	if strings.TrimSpace(spec) == "" {
+		spec = "info"
+	}
+	levels := make(map[string]slog.Level)
+	for _, item := range strings.Split(spec, ",") {
+		subsystem, name := "", strings.TrimSpace(item)
+		if i := strings.Index(name, "="); i >= 0 {
+			subsystem, name = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+			if _, ok := l.levels[subsystem]; !ok {
+				return fmt.Errorf("unknown log subsystem %q, want one of %s", subsystem, strings.Join(logSubsystems, ", "))
+			}
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return fmt.Errorf("bad log level %q", name)
+		}
+		if subsystem == "" {
+			for _, s := range logSubsystems {
+				levels[s] = level
+			}
+		} else {
+			levels[subsystem] = level
+		}
+	}
+	for subsystem, level := range levels {
+		l.levels[subsystem].Set(level)
+	}
+	return nil
+}
+
+// getLevels returns the level of each subsystem
+func (l *logs) getLevels() map[string]string {
+	levels := make(map[string]string)
+	for subsystem, level := range l.levels {
+		levels[subsystem] = strings.ToLower(level.Level().String())
+	}
+	return levels
+}
+
+// String returns the levels in the form setLevels takes
+func (l *logs) String() string {
+	levels := l.getLevels()
+	items := make([]string, 0, len(levels))
+	for subsystem, level := range levels {
+		items = append(items, subsystem+"="+level)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// handleAdminLog reports the log levels, or changes them to the levels in the
+// request body
+func (node *Node) handleAdminLog(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"GET", "PUT"}, r, w) {
+		return
+	}
+
+	if r.Method == "PUT" {
+		spec, err := ioutil.ReadAll(io.LimitReader(r.Body, 4096))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_body", "%s", err)
+			return
+		}
+		if err := node.logs.setLevels(string(spec)); err != nil {
+			writeError(w, http.StatusBadRequest, "bad_level", "%s", err)
+			return
+		}
+		node.logs.rest.Infof("Logging at %s", node.logs)
+	}
+	writeJSON(w, http.StatusOK, node.logs.getLevels())
+}
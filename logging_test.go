@@ -0,0 +1,115 @@
+package kademlia
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSetLevels(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+		ok   bool
+	}{
+		{"", "rest=info,routing=info,rpc=info,store=info", true},
+		{"debug", "rest=debug,routing=debug,rpc=debug,store=debug", true},
+		{"WARN, routing=debug", "rest=warn,routing=debug,rpc=warn,store=warn", true},
+		{"routing=debug,error", "rest=error,routing=error,rpc=error,store=error", true},
+		{"rest=error", "rest=error,routing=info,rpc=info,store=info", true},
+		{"loud", "", false},
+		{"routing=loud", "", false},
+		{"dht=debug", "", false},
+		{"debug,", "", false},
+	}
+	for _, test := range tests {
+		l, err := newLogs(&bytes.Buffer{}, false, "info", "10.0.0.1:4000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = l.setLevels(test.spec)
+		if (err == nil) != test.ok {
+			t.Errorf("setLevels(%q) error = %v, want ok %t", test.spec, err, test.ok)
+			continue
+		}
+		want := test.want
+		if !test.ok {
+			// a bad spec changes nothing
+			want = "rest=info,routing=info,rpc=info,store=info"
+		}
+		if got := l.String(); got != want {
+			t.Errorf("setLevels(%q) gave %s, want %s", test.spec, got, want)
+		}
+	}
+}
+
+func TestLogOutput(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := newLogs(&buf, true, "warn,routing=debug", "10.0.0.1:4000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.routing.Debugf("round %d", 1)
+	l.store.Infof("dropped")
+	l.store.Warnf("rejected %s", "key")
+	l.rpc.Info("sending")
+	l.rpc.Warn("RPC failed", "peer", "10.0.0.2:4000", "attempts", 2)
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("bad record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3: %s", len(records), buf.String())
+	}
+	for i, want := range []map[string]interface{}{
+		{"level": "DEBUG", "msg": "round 1", "subsystem": "routing", "node": "10.0.0.1:4000"},
+		{"level": "WARN", "msg": "rejected key", "subsystem": "store", "node": "10.0.0.1:4000"},
+		{"level": "WARN", "msg": "RPC failed", "subsystem": "rpc", "peer": "10.0.0.2:4000", "attempts": 2.0},
+	} {
+		for field, value := range want {
+			if records[i][field] != value {
+				t.Errorf("record %d has %s %v, want %v", i, field, records[i][field], value)
+			}
+		}
+		// the source is the caller, not the logging helpers
+		source, _ := records[i]["source"].(map[string]interface{})
+		if file, _ := source["file"].(string); !strings.HasSuffix(file, "logging_test.go") {
+			t.Errorf("record %d has source %v", i, records[i]["source"])
+		}
+	}
+}
+
+func TestAdminLog(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	request := func(method string, body string) (int, map[string]string) {
+		w := httptest.NewRecorder()
+		node.handleAdminLog(w, httptest.NewRequest(method, "/admin/log", strings.NewReader(body)))
+		var levels map[string]string
+		json.Unmarshal(w.Body.Bytes(), &levels)
+		return w.Code, levels
+	}
+
+	code, levels := request("PUT", "error,rpc=debug")
+	want := map[string]string{"routing": "error", "store": "error", "rpc": "debug", "rest": "error"}
+	if code != http.StatusOK || !reflect.DeepEqual(levels, want) {
+		t.Errorf("PUT returned %d %v, want %v", code, levels, want)
+	}
+	if code, levels = request("GET", ""); code != http.StatusOK || !reflect.DeepEqual(levels, want) {
+		t.Errorf("GET returned %d %v, want %v", code, levels, want)
+	}
+	if code, _ = request("PUT", "rpc=chatty"); code != http.StatusBadRequest {
+		t.Errorf("PUT of a bad level returned %d", code)
+	}
+	if code, _ = request("POST", "debug"); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST returned %d", code)
+	}
+}
@@ -0,0 +1,127 @@
+package kademlia_test
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/quick"
+
+	"github.com/peterdelong/kademlia"
+	"github.com/peterdelong/kademlia/sim"
+)
+
+const (
+	lookupNodes = 300
+	k           = 4
+)
+
+// lookupNetwork is a simulated network where every node is known, so the true
+// k closest nodes to any ID can be worked out
+type lookupNetwork struct {
+	sim *sim.Simulator
+	ids []kademlia.NodeID
+}
+
+var network *lookupNetwork
+
+func getNetwork() *lookupNetwork {
+	if network == nil {
+		s := sim.New(sim.Config{Seed: 1})
+		s.AddNodes(lookupNodes)
+		ids := make([]kademlia.NodeID, lookupNodes)
+		for i := range ids {
+			ids[i] = kademlia.NodeIDFromString(s.Addr(i))
+		}
+		network = &lookupNetwork{s, ids}
+	}
+	return network
+}
+
+// trueClosest returns the addresses of the k nodes closest to target
+func (n *lookupNetwork) trueClosest(target kademlia.NodeID) []string {
+	indexes := make([]int, len(n.ids))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	distance := func(i int) kademlia.NodeID {
+		return target.Xor(n.ids[i])
+	}
+	sort.Slice(indexes, func(a, b int) bool {
+		return distance(indexes[a]).Cmp(distance(indexes[b])) < 0
+	})
+	closest := make([]string, k)
+	for i := range closest {
+		closest[i] = n.sim.Addr(indexes[i])
+	}
+	return closest
+}
+
+// lookupTarget is a random ID and a random node to look it up from
+type lookupTarget struct {
+	ID   [20]byte
+	From int
+}
+
+func (lookupTarget) Generate(r *rand.Rand, size int) reflect.Value {
+	var target lookupTarget
+	r.Read(target.ID[:])
+	target.From = r.Intn(lookupNodes)
+	return reflect.ValueOf(target)
+}
+
+// TestIterativeFindNodeFindsClosest checks that a lookup from any node for any
+// ID returns exactly the k closest nodes, in order. The node doing the lookup
+// only appears if another node told it about itself.
+func TestIterativeFindNodeFindsClosest(t *testing.T) {
+	n := getNetwork()
+	property := func(target lookupTarget) bool {
+		id := hex.EncodeToString(target.ID[:])
+		self := n.sim.Addr(target.From)
+		want := n.trueClosest(kademlia.NodeID(target.ID))
+		contacts, _ := n.sim.FindNode(target.From, id)
+		got := make([]string, len(contacts))
+		for i, contact := range contacts {
+			got[i] = contact.Addr.String()
+		}
+		if !reflect.DeepEqual(got, want) && !reflect.DeepEqual(got, without(want, self)) {
+			t.Logf("lookup of %s from %s: got %v, want %v", id, self, got, want)
+			return false
+		}
+		return true
+	}
+	config := &quick.Config{MaxCount: 200, Rand: rand.New(rand.NewSource(1))}
+	if err := quick.Check(property, config); err != nil {
+		t.Error(err)
+	}
+}
+
+// without returns addrs with addr left out
+func without(addrs []string, addr string) []string {
+	result := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if a != addr {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// TestIterativeFindNodeOwnID checks that looking up a node's own ID finds the
+// node itself from anywhere in the network
+func TestIterativeFindNodeOwnID(t *testing.T) {
+	n := getNetwork()
+	property := func(target lookupTarget, other uint16) bool {
+		to := int(other) % lookupNodes
+		if to == target.From {
+			return true
+		}
+		contacts, _ := n.sim.FindNode(target.From, n.ids[to].String())
+		return len(contacts) > 0 && contacts[0].Addr.String() == n.sim.Addr(to)
+	}
+	config := &quick.Config{MaxCount: 200, Rand: rand.New(rand.NewSource(2))}
+	if err := quick.Check(property, config); err != nil {
+		t.Error(err)
+	}
+}
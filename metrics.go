@@ -0,0 +1,276 @@
+package kademlia
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the RPC and lookup
+// duration histograms
+var latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// hopBuckets are the upper bounds of the lookup hop count histogram
+var hopBuckets = []float64{0, 1, 2, 3, 4, 5, 6, 8, 10, 15, 20}
+
+// histogram counts observations into cumulative buckets, the way Prometheus
+// histograms do
+type histogram struct {
+	bounds []float64
+	// counts[i] is the number of observations in (bounds[i-1], bounds[i]],
+	// with the last for those above every bound
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// metrics holds the counters and histograms a node exposes on /metrics. The
+// gauges (routing table and store sizes) are read from the node when scraped.
+type metrics struct {
+	mu sync.Mutex
+	// RPCs by method and outcome ("ok" or "error")
+	rpcsSent     map[[2]string]uint64
+	rpcsReceived map[[2]string]uint64
+	// rpcLatency is the time taken by RPCs we sent, by method
+	rpcLatency map[string]*histogram
+	// lookup hops and durations by kind ("findnode" or "findvalue")
+	lookupHops     map[string]*histogram
+	lookupDuration map[string]*histogram
+	// cacheHits counts lookups answered by a cached copy, and cacheStores the
+	// copies we sent to be cached, by outcome
+	cacheHits   uint64
+	cacheStores map[string]uint64
+	// dials counts connections made by rpcTransport, by outcome. There is no
+	// pooling: every RPC dials its own connection.
+	dials map[string]uint64
+	// open is the number of connections currently open, updated atomically
+	open int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		rpcsSent:       make(map[[2]string]uint64),
+		rpcsReceived:   make(map[[2]string]uint64),
+		rpcLatency:     make(map[string]*histogram),
+		lookupHops:     make(map[string]*histogram),
+		lookupDuration: make(map[string]*histogram),
+		cacheStores:    make(map[string]uint64),
+		dials:          make(map[string]uint64),
+	}
+}
+
+// outcome returns the outcome label for an RPC that succeeded if ok is set
+func outcome(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "error"
+}
+
+// rpcSent records an RPC we sent to another node
+func (m *metrics) rpcSent(method string, ok bool, took time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rpcsSent[[2]string{method, outcome(ok)}]++
+	h, exists := m.rpcLatency[method]
+	if !exists {
+		h = newHistogram(latencyBuckets)
+		m.rpcLatency[method] = h
+	}
+	h.observe(took.Seconds())
+}
+
+// rpcReceived records an RPC another node sent us, which failed if err is set
+func (m *metrics) rpcReceived(method string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rpcsReceived[[2]string{method, outcome(err == nil)}]++
+}
+
+// rpcReceived records an RPC from source, which failed if err is set. The
+// RPCs a node makes to itself, such as storing its own replica, aren't counted.
+func (node *Node) rpcReceived(method string, source net.TCPAddr, err error) {
+	if source.String() == node.addr.String() {
+		return
+	}
+	node.metrics.rpcReceived(method, err)
+}
+
+// lookup records an iterative lookup that sent rounds rounds of RPCs
+func (m *metrics) lookup(kind string, rounds int, took time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.lookupHops[kind]; !exists {
+		m.lookupHops[kind] = newHistogram(hopBuckets)
+		m.lookupDuration[kind] = newHistogram(latencyBuckets)
+	}
+	m.lookupHops[kind].observe(float64(rounds))
+	m.lookupDuration[kind].observe(took.Seconds())
+}
+
+func (m *metrics) cacheHit() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cacheHits++
+}
+
+func (m *metrics) cacheStore(ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cacheStores[outcome(ok)]++
+}
+
+// dialed records a connection attempt, which is open until closed is called
+// if it succeeded
+func (m *metrics) dialed(ok bool) {
+	m.mu.Lock()
+	m.dials[outcome(ok)]++
+	m.mu.Unlock()
+	if ok {
+		atomic.AddInt64(&m.open, 1)
+	}
+}
+
+func (m *metrics) closed() {
+	atomic.AddInt64(&m.open, -1)
+}
+
+// metricsWriter writes the Prometheus text exposition format
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (mw metricsWriter) header(name string, kind string, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (mw metricsWriter) sample(name string, labels string, v float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(mw.w, "%s%s %s\n", name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (mw metricsWriter) histogram(name string, labels string, h *histogram) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		mw.sample(name+"_bucket", fmt.Sprintf("%s%sle=%q", labels, sep, strconv.FormatFloat(bound, 'g', -1, 64)), float64(cumulative))
+	}
+	mw.sample(name+"_bucket", fmt.Sprintf("%s%sle=\"+Inf\"", labels, sep), float64(h.count))
+	mw.sample(name+"_sum", labels, h.sum)
+	mw.sample(name+"_count", labels, float64(h.count))
+}
+
+// rpcCounters writes counters labelled by method and outcome, in order
+func (mw metricsWriter) rpcCounters(name string, counts map[[2]string]uint64) {
+	keys := make([][2]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		mw.sample(name, fmt.Sprintf("method=%q,outcome=%q", key[0], key[1]), float64(counts[key]))
+	}
+}
+
+// outcomeCounters writes counters labelled by outcome, in order
+func (mw metricsWriter) outcomeCounters(name string, counts map[string]uint64) {
+	for _, o := range []string{"error", "ok"} {
+		mw.sample(name, fmt.Sprintf("outcome=%q", o), float64(counts[o]))
+	}
+}
+
+// histograms writes histograms labelled by label, in order
+func (mw metricsWriter) histograms(name string, label string, hs map[string]*histogram) {
+	names := make([]string, 0, len(hs))
+	for name := range hs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, value := range names {
+		mw.histogram(name, fmt.Sprintf("%s=%q", label, value), hs[value])
+	}
+}
+
+// writeMetrics writes every metric of the node to w in the Prometheus text
+// exposition format
+func (node *Node) writeMetrics(w io.Writer) error {
+	mw := metricsWriter{bufio.NewWriter(w)}
+	m := node.metrics
+
+	m.mu.Lock()
+	mw.header("kademlia_rpcs_sent_total", "counter", "RPCs sent to other nodes.")
+	mw.rpcCounters("kademlia_rpcs_sent_total", m.rpcsSent)
+	mw.header("kademlia_rpcs_received_total", "counter", "RPCs received from other nodes.")
+	mw.rpcCounters("kademlia_rpcs_received_total", m.rpcsReceived)
+	mw.header("kademlia_rpc_duration_seconds", "histogram", "Time taken by RPCs sent to other nodes.")
+	mw.histograms("kademlia_rpc_duration_seconds", "method", m.rpcLatency)
+	mw.header("kademlia_lookup_hops", "histogram", "Rounds of RPCs sent by iterative lookups.")
+	mw.histograms("kademlia_lookup_hops", "kind", m.lookupHops)
+	mw.header("kademlia_lookup_duration_seconds", "histogram", "Time taken by iterative lookups.")
+	mw.histograms("kademlia_lookup_duration_seconds", "kind", m.lookupDuration)
+	mw.header("kademlia_cache_hits_total", "counter", "Lookups answered by a cached copy of the value.")
+	mw.sample("kademlia_cache_hits_total", "", float64(m.cacheHits))
+	mw.header("kademlia_cache_stores_total", "counter", "Values sent to another node to be cached.")
+	mw.outcomeCounters("kademlia_cache_stores_total", m.cacheStores)
+	mw.header("kademlia_connection_dials_total", "counter", "Connections dialled to send RPCs.")
+	mw.outcomeCounters("kademlia_connection_dials_total", m.dials)
+	m.mu.Unlock()
+	mw.header("kademlia_connections_open", "gauge", "Connections currently open to send RPCs.")
+	mw.sample("kademlia_connections_open", "", float64(atomic.LoadInt64(&m.open)))
+
+	mw.header("kademlia_routing_bucket_contacts", "gauge", "Contacts in each non-empty k-bucket.")
+	total := 0
+	for index, bucket := range node.rt.kBuckets {
+		if n := len(bucket.getAllContacts()); n > 0 {
+			mw.sample("kademlia_routing_bucket_contacts", fmt.Sprintf("bucket=\"%d\"", index), float64(n))
+			total += n
+		}
+	}
+	mw.header("kademlia_routing_contacts", "gauge", "Contacts in the routing table.")
+	mw.sample("kademlia_routing_contacts", "", float64(total))
+
+	keys, bytes := node.ht.size()
+	mw.header("kademlia_store_keys", "gauge", "Keys held, including tombstones and cached copies.")
+	mw.sample("kademlia_store_keys", "", float64(keys))
+	mw.header("kademlia_store_bytes", "gauge", "Bytes of values held.")
+	mw.sample("kademlia_store_bytes", "", float64(bytes))
+	return mw.w.Flush()
+}
+
+func (node *Node) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"GET"}, r, w) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	node.writeMetrics(w)
+}
@@ -0,0 +1,89 @@
+package kademlia
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrape returns the samples on /metrics by name and labels
+func scrape(t *testing.T, node *Node) map[string]float64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	node.handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /metrics returned %d", w.Code)
+	}
+	samples := make(map[string]float64)
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample %q: %v", line, err)
+		}
+		samples[line[:i]] = v
+	}
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	source := net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4000}
+	key := ContentKey([]byte("value"))
+
+	node.doPing(source)
+	node.Store(StoreArgs{Source: source, Key: key, Val: []byte("value")}, &StoreReply{})
+	node.Store(StoreArgs{Source: source, Key: "not a key"}, &StoreReply{})
+	// calls to ourselves aren't RPCs we received
+	node.Store(StoreArgs{Source: node.addr, Key: key, Val: []byte("value")}, &StoreReply{})
+	node.FindValue(FindValueArgs{node.addr, key}, &FindValueReply{})
+	// the lookup asks the source of the stores and one other contact in a
+	// single round, and neither answers
+	node.rt.add(testContact(1))
+	node.doIterativeFindNode(key)
+
+	samples := scrape(t, node)
+	want := map[string]float64{
+		`kademlia_rpcs_sent_total{method="Ping",outcome="error"}`:      1,
+		`kademlia_rpcs_sent_total{method="FindNode",outcome="error"}`:  2,
+		`kademlia_rpcs_received_total{method="Store",outcome="ok"}`:    1,
+		`kademlia_rpcs_received_total{method="Store",outcome="error"}`: 1,
+		`kademlia_rpc_duration_seconds_count{method="Ping"}`:           1,
+		`kademlia_lookup_hops_bucket{kind="findnode",le="0"}`:          0,
+		`kademlia_lookup_hops_bucket{kind="findnode",le="1"}`:          1,
+		`kademlia_lookup_hops_bucket{kind="findnode",le="+Inf"}`:       1,
+		`kademlia_lookup_hops_sum{kind="findnode"}`:                    1,
+		`kademlia_lookup_duration_seconds_count{kind="findnode"}`:      1,
+		`kademlia_cache_stores_total{outcome="ok"}`:                    0,
+		`kademlia_routing_contacts`:                                    2,
+		`kademlia_store_keys`:                                          1,
+		`kademlia_store_bytes`:                                         5,
+		`kademlia_connections_open`:                                    0,
+	}
+	for name, v := range want {
+		got, ok := samples[name]
+		if !ok {
+			t.Errorf("%s is missing", name)
+		} else if got != v {
+			t.Errorf("%s = %g, want %g", name, got, v)
+		}
+	}
+	if _, ok := samples[`kademlia_rpcs_received_total{method="FindValue",outcome="ok"}`]; ok {
+		t.Error("FINDVALUE sent to ourselves was counted")
+	}
+}
+
+func TestMetricsMethod(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	w := httptest.NewRecorder()
+	node.handleMetrics(w, httptest.NewRequest("POST", "/metrics", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /metrics returned %d", w.Code)
+	}
+}
@@ -0,0 +1,138 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+// MutableRecord holds the ownership and versioning information for a mutable
+// value, along the lines of BitTorrent's BEP 44. The value is stored under
+// MutableKey(PublicKey, Salt) and can only be replaced by a value signed by
+// the owner with a higher sequence number.
+type MutableRecord struct {
+	PublicKey []byte
+	Salt      []byte
+	Seq       int64
+	Sig       []byte
+}
+
+// MutableKey returns the key that records owned by publicKey with the given
+// salt are stored under
+func MutableKey(publicKey []byte, salt []byte) string {
+	buf := make([]byte, 0, len(publicKey)+len(salt))
+	buf = append(buf, publicKey...)
+	buf = append(buf, salt...)
+	return ContentKey(buf)
+}
+
+// mutableSigData returns the bytes covered by the owner's signature, encoded
+// the same way as BEP 44
+func mutableSigData(salt []byte, seq int64, val []byte) []byte {
+	buf := make([]byte, 0, len(salt)+len(val)+32)
+	if len(salt) > 0 {
+		buf = append(buf, fmt.Sprintf("4:salt%d:", len(salt))...)
+		buf = append(buf, salt...)
+	}
+	buf = append(buf, fmt.Sprintf("3:seqi%de1:v%d:", seq, len(val))...)
+	buf = append(buf, val...)
+	return buf
+}
+
+// SignMutable returns a record for val at sequence number seq signed with
+// privateKey
+func SignMutable(privateKey ed25519.PrivateKey, salt []byte, seq int64, val []byte) *MutableRecord {
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	return &MutableRecord{
+		PublicKey: []byte(publicKey),
+		Salt:      salt,
+		Seq:       seq,
+		Sig:       ed25519.Sign(privateKey, mutableSigData(salt, seq, val)),
+	}
+}
+
+// verify checks that record was signed by its owner for val and belongs under
+// key
+func (record *MutableRecord) verify(key string, val []byte) error {
+	if len(record.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+	if !isContentKey(key, append(append([]byte{}, record.PublicKey...), record.Salt...)) {
+		return errors.New("key does not match public key and salt")
+	}
+	if !ed25519.Verify(record.PublicKey, mutableSigData(record.Salt, record.Seq, val), record.Sig) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// checkMutableUpdate returns an error if the signed update (record, val) may
+// not replace existing. cas, if set, is the sequence number the publisher
+// expects to be replacing.
+func checkMutableUpdate(existing *KV, record *MutableRecord, val []byte, cas *int64) error {
+	if existing == nil || existing.mutable == nil {
+		if cas != nil {
+			return errors.New("compare-and-swap failed: no current value")
+		}
+		return nil
+	}
+	current := existing.mutable.Seq
+	if cas != nil && *cas != current {
+		return fmt.Errorf("compare-and-swap failed: current sequence number is %d", current)
+	}
+	if record.Seq < current {
+		return fmt.Errorf("sequence number %d is less than current %d", record.Seq, current)
+	}
+	if record.Seq == current && string(val) != string(existing.val) {
+		return fmt.Errorf("sequence number %d is not newer than current value", record.Seq)
+	}
+	return nil
+}
+
+// Iteratively look up the mutable record stored under key
+// Unlike doIterativeFindValue, all of the k closest nodes are asked and the
+// validly signed value with the highest sequence number wins
+func (node *Node) doIterativeGetMutable(key string) ([]byte, *MutableRecord) {
+	var bestVal []byte
+	var best *MutableRecord
+
+	if kv, ok := node.ht.getKV(key); ok && kv.mutable != nil {
+		bestVal = kv.val
+		best = kv.mutable
+	}
+
+	closest := node.doIterativeFindNode(key)
+
+	type result struct {
+		val    []byte
+		record *MutableRecord
+	}
+	resultChan := make(chan *result, len(closest))
+	node.transport.Parallel(len(closest), func(i int) {
+		contact := closest[i]
+		response := node.doFindValue(key, contact.Addr)
+		if response == nil || response.Val == nil || response.Mutable == nil {
+			resultChan <- nil
+			return
+		}
+		if err := response.Mutable.verify(key, response.Val); err != nil {
+			node.logs.store.Warn("Discarding mutable value", "peer", contact.Addr.String(), "err", err)
+			resultChan <- nil
+			return
+		}
+		resultChan <- &result{response.Val, response.Mutable}
+	})
+
+	for i := 0; i < len(closest); i++ {
+		res := <-resultChan
+		if res == nil {
+			continue
+		}
+		if best == nil || res.record.Seq > best.Seq {
+			bestVal = res.val
+			best = res.record
+		}
+	}
+
+	return bestVal, best
+}
@@ -2,28 +2,49 @@ package kademlia
 
 import (
 	"bufio"
-	"crypto/sha1"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"math/big"
 	"net"
 	"net/http"
 	"net/rpc"
 	"os"
-	//"time"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // Node is an individual Kademlia node
 type Node struct {
-	id     big.Int
+	id     NodeID
 	addr   net.TCPAddr
 	ht     KVStore
 	rt     *RoutingTable
-	logger *log.Logger
+	logs   *logs
+	config Config
+	// transport sends our RPCs to other nodes
+	transport Transport
+	// leaving is set once Leave is called, so we stop storing values on
+	// ourselves
+	leaving int32
+	// metrics are exposed on /metrics
+	metrics *metrics
+	// traces holds the most recent lookup traces
+	traces *traceRing
+	// started is when the node was created, for the uptime on /info
+	started time.Time
+	// joinState is joining until Join returns, then joined or, if there was
+	// no one to join, startedNetwork
+	joinState int32
 }
 
+// The values of Node.joinState
+const (
+	joining int32 = iota
+	joined
+	startedNetwork
+)
+
 // PingArgs contains the arguments for the PING RPC
 type PingArgs struct {
 	Source net.TCPAddr
@@ -39,10 +60,40 @@ type StoreArgs struct {
 	Source net.TCPAddr
 	Key    string
 	Val    []byte
+	// Immutable values must hash to Key (see ContentKey)
+	Immutable bool
+	// Mutable is set for signed values owned by a keypair
+	Mutable *MutableRecord
+	// Cas, if set, is the sequence number of the mutable value this store
+	// expects to replace
+	Cas *int64
+	// Published is when the original publisher stored the value
+	Published time.Time
+	// OwnerHash is the hash of a secret chosen by the publisher, which must be
+	// presented to delete the value (see OwnerHash)
+	OwnerHash []byte
+	// TTL is how much longer the value should live, zero for the default of
+	// tExpire. Storing nodes cap it at their Config.MaxTTL.
+	TTL time.Duration
+	// Publisher is who the value was first stored for, such as the REST
+	// client that asked us to publish it. Only stores a node makes to itself
+	// are charged to Publisher under Config.MaxKeysPerPublisher, since other
+	// nodes could claim anyone; their stores are charged to Source.
+	Publisher string
+	// Cached is set when storing a copy found by a lookup
+	Cached bool
+	// Erasure is set when Val is an ErasureManifest, whose shards the holder
+	// should keep repaired
+	Erasure bool
+	// Name is the human-readable name Key was derived from, if any (see
+	// NameKey)
+	Name string
 }
 
 // StoreReply contains the results for the Store RPC
 type StoreReply struct {
+	// Err is set when the value was rejected
+	Err string
 }
 
 // FindValueArgs contains the arguments for the FINDVALUE RPC
@@ -55,6 +106,40 @@ type FindValueArgs struct {
 type FindValueReply struct {
 	Val      []byte
 	Contacts []Contact
+	// Mutable is set if Val is a signed mutable value
+	Mutable *MutableRecord
+	// Published, OwnerHash, TTL and Publisher are as in StoreArgs, except
+	// that Published is when the key was deleted if Deleted is set
+	Published time.Time
+	OwnerHash []byte
+	TTL       time.Duration
+	Publisher string
+	Name      string
+	// Immutable and Erasure are as in StoreArgs
+	Immutable bool
+	Erasure   bool
+	// Cached is set if Val is a copy cached by a lookup
+	Cached bool
+	// Deleted is set if the key has been deleted
+	Deleted bool
+}
+
+// DeleteArgs contains the arguments for the DELETE RPC
+// Deletes of mutable values are authorized by a signed empty value with a
+// higher sequence number, others by the secret the value was stored with
+type DeleteArgs struct {
+	Source  net.TCPAddr
+	Key     string
+	Secret  []byte
+	Mutable *MutableRecord
+	// Deleted is when the delete was issued
+	Deleted time.Time
+}
+
+// DeleteReply contains the results for the DELETE RPC
+type DeleteReply struct {
+	// Err is set when the delete was refused
+	Err string
 }
 
 // FindNodeArgs contains the arguments for the FINDNODE RPC
@@ -69,14 +154,15 @@ type FindNodeReply struct {
 }
 
 // Ping is the handler for the PING RPC
-func (node *Node) Ping(args PingArgs, reply *PingReply) error {
+func (node *Node) Ping(args PingArgs, reply *PingReply) (err error) {
+	defer func() { node.rpcReceived("Ping", args.Source, err) }()
 	contact := NewContact(args.Source)
 
-	node.logger.Printf("Ping from %s", args.Source.String())
+	node.logs.rpc.Debug("Ping", "from", args.Source.String())
 	if contact == nil {
 		return errors.New("Couldn't hash IP address")
 	}
-	node.rt.add(*contact)
+	node.rt.seen(*contact, 0)
 
 	// Update k-bucket based on args.Source
 	*reply = PingReply{node.addr}
@@ -85,96 +171,307 @@ func (node *Node) Ping(args PingArgs, reply *PingReply) error {
 }
 
 func (node *Node) checkRoutingTable(dest net.TCPAddr) {
-	node.logger.Printf("Checking routing table")
-	hash := sha1.Sum([]byte(dest.String()))
-
-	id := *big.NewInt(0)
-	id.SetBytes(hash[:])
-
-	contact := node.rt.ContactFromID(id)
+	node.logs.routing.Debugf("Checking routing table")
+	contact := node.rt.ContactFromID(NodeIDFromString(dest.String()))
 	if contact == nil {
-		node.logger.Printf("Node not added")
+		node.logs.routing.Debugf("Node not added")
 		return
 	}
-	node.logger.Printf("Printing node info")
+	node.logs.routing.Debugf("Printing node info")
 
-	node.logger.Printf("Id: %s, addr: %s", contact.Id.Text(keyBase), contact.Addr.String())
+	node.logs.routing.Debugf("Id: %s, addr: %s", contact.Id, contact.Addr.String())
 }
 
 // Store is the handler for the STORE RPC
-func (node *Node) Store(args StoreArgs, reply *StoreReply) error {
+func (node *Node) Store(args StoreArgs, reply *StoreReply) (err error) {
+	defer func() { node.rpcReceived("Store", args.Source, err) }()
 	contact := NewContact(args.Source)
 	if contact == nil {
 		return errors.New("Couldn't hash IP address")
 	}
-	node.rt.add(*contact)
+	node.rt.seen(*contact, 0)
+
+	id, err := ParseKey(args.Key)
+	if err != nil {
+		*reply = StoreReply{Err: err.Error()}
+		return err
+	}
+	// keys are stored in one form, whatever form they arrive in
+	args.Key = id.String()
+	if args.Name != "" && !isNameKey(args.Key, args.Name) {
+		err := fmt.Errorf("key %s is not the key for name %q", args.Key, args.Name)
+		*reply = StoreReply{Err: err.Error()}
+		return err
+	}
+
+	err = node.ht.update(args.Key, func(existing *KV) (*KV, error) {
+		// once a key holds a content-addressed value, only that value may be
+		// stored under it
+		immutable := args.Immutable || (existing != nil && existing.immutable)
+		if immutable && !isContentKey(args.Key, args.Val) {
+			return nil, errors.New("value does not match content-addressed key")
+		}
+
+		if args.Mutable != nil {
+			if err := args.Mutable.verify(args.Key, args.Val); err != nil {
+				return nil, err
+			}
+			if err := checkMutableUpdate(existing, args.Mutable, args.Val, args.Cas); err != nil {
+				return nil, err
+			}
+		} else if existing != nil && existing.mutable != nil && !existing.tombstone {
+			return nil, errors.New("key holds a mutable value, updates must be signed")
+		}
+
+		published := args.Published
+		if published.IsZero() {
+			published = time.Now()
+		}
+		// only values published after the delete may replace a tombstone
+		if existing != nil && existing.tombstone {
+			newerSeq := args.Mutable != nil && (existing.mutable == nil || args.Mutable.Seq > existing.mutable.Seq)
+			sameOwner := string(args.OwnerHash) == string(existing.ownerHash)
+			if (!existing.unverified || sameOwner) && !newerSeq && !published.After(existing.deleted) {
+				return nil, errors.New("key has been deleted")
+			}
+		}
+
+		// a peer is charged for what it sends us, whoever it says it's for
+		publisher := args.Source.String()
+		if publisher == node.addr.String() && args.Publisher != "" {
+			publisher = args.Publisher
+		}
 
-	// TODO: Might have to check if we're already the origin before overwriting
-	// with false
-	node.ht.add(args.Key, args.Val, false)
+		kv := &KV{
+			key:       args.Key,
+			val:       args.Val,
+			isOrigin:  existing != nil && existing.isOrigin && !existing.tombstone,
+			immutable: immutable,
+			mutable:   args.Mutable,
+			published: published,
+			ownerHash: args.OwnerHash,
+			expires:   time.Now().Add(node.config.capTTL(args.TTL)),
+			publisher: publisher,
+			erasure:   args.Erasure && immutable,
+			name:      args.Name,
+			// a cached copy doesn't demote a replica we already hold
+			cached: args.Cached && (existing == nil || existing.cached || existing.tombstone),
+		}
+		if err := node.ht.checkLimits(&node.config, existing, kv); err != nil {
+			return nil, err
+		}
+		return kv, nil
+	})
+	if err != nil {
+		node.logs.store.Warn("Rejecting STORE", "key", args.Key, "from", args.Source.String(), "err", err)
+		*reply = StoreReply{Err: err.Error()}
+		return err
+	}
 
 	*reply = StoreReply{}
 	return nil
 }
 
+// Delete is the handler for the DELETE RPC
+// The value is replaced by a tombstone, which is kept for tExpire
+func (node *Node) Delete(args DeleteArgs, reply *DeleteReply) (err error) {
+	defer func() { node.rpcReceived("Delete", args.Source, err) }()
+	contact := NewContact(args.Source)
+	if contact == nil {
+		return errors.New("Couldn't hash IP address")
+	}
+	node.rt.seen(*contact, 0)
+
+	id, err := ParseKey(args.Key)
+	if err != nil {
+		*reply = DeleteReply{Err: err.Error()}
+		return err
+	}
+	args.Key = id.String()
+
+	deleted := args.Deleted
+	if deleted.IsZero() || deleted.After(time.Now()) {
+		deleted = time.Now()
+	}
+
+	err = node.ht.update(args.Key, func(existing *KV) (*KV, error) {
+		if existing != nil && existing.tombstone && (!existing.unverified || !deleted.After(existing.deleted)) {
+			// already deleted
+			return existing, nil
+		}
+		if err := checkDeleteAuthorized(args, existing); err != nil {
+			return nil, err
+		}
+
+		tombstone := &KV{
+			key:          args.Key,
+			tombstone:    true,
+			deleted:      deleted,
+			deleteSecret: args.Secret,
+			mutable:      args.Mutable,
+		}
+		if existing != nil && !existing.tombstone {
+			tombstone.isOrigin = existing.isOrigin
+			tombstone.ownerHash = existing.ownerHash
+		} else if args.Mutable == nil {
+			// we never saw the value, so we can't tell if the secret is right
+			tombstone.ownerHash = OwnerHash(args.Secret)
+			tombstone.unverified = existing == nil || existing.unverified
+			// anyone can leave one of these, so they count against the sender
+			tombstone.publisher = args.Source.String()
+		}
+		if err := node.ht.checkLimits(&node.config, existing, tombstone); err != nil {
+			return nil, err
+		}
+		return tombstone, nil
+	})
+	if err != nil {
+		node.logs.store.Warn("Rejecting DELETE", "key", args.Key, "from", args.Source.String(), "err", err)
+		*reply = DeleteReply{Err: err.Error()}
+		return err
+	}
+
+	node.logs.store.Info("Deleted key", "key", args.Key)
+	*reply = DeleteReply{}
+	return nil
+}
+
+// checkDeleteAuthorized returns an error if args doesn't prove ownership of
+// existing, which is nil if we don't have the key
+func checkDeleteAuthorized(args DeleteArgs, existing *KV) error {
+	if args.Mutable != nil {
+		if err := args.Mutable.verify(args.Key, nil); err != nil {
+			return err
+		}
+		if existing != nil && existing.mutable != nil && args.Mutable.Seq <= existing.mutable.Seq {
+			return fmt.Errorf("sequence number %d is not newer than current value", args.Mutable.Seq)
+		}
+		return nil
+	}
+
+	if existing == nil || existing.tombstone {
+		if args.Secret == nil {
+			return errors.New("delete must include a secret or signed record")
+		}
+		return nil
+	}
+	if existing.mutable != nil {
+		return errors.New("key holds a mutable value, deletes must be signed")
+	}
+	if existing.ownerHash == nil {
+		return errors.New("value has no owner and cannot be deleted")
+	}
+	if string(OwnerHash(args.Secret)) != string(existing.ownerHash) {
+		return errors.New("secret does not match")
+	}
+	return nil
+}
+
 // FindValue is the handler for the FINDVALUE RPC
-func (node *Node) FindValue(args FindValueArgs, reply *FindValueReply) error {
+func (node *Node) FindValue(args FindValueArgs, reply *FindValueReply) (err error) {
+	defer func() { node.rpcReceived("FindValue", args.Source, err) }()
 	contact := NewContact(args.Source)
 	if contact == nil {
 		return errors.New("Couldn't hash IP address")
 	}
-	node.rt.add(*contact)
+	node.rt.seen(*contact, 0)
+	toFindID, err := ParseKey(args.Key)
+	if err != nil {
+		return err
+	}
+	args.Key = toFindID.String()
 	// If node contains key, returns associated data
-	if val, ok := node.ht.get(args.Key); ok {
-		*reply = FindValueReply{Val: val}
-		return nil
+	if kv, ok := node.ht.getKV(args.Key); ok && !(kv.tombstone && kv.unverified) {
+		if kv.tombstone {
+			*reply = FindValueReply{Deleted: true, Published: kv.deleted}
+			return nil
+		}
+		if ttl := kv.ttl(); ttl > 0 {
+			*reply = FindValueReply{
+				Val:       kv.val,
+				Mutable:   kv.mutable,
+				Published: kv.published,
+				OwnerHash: kv.ownerHash,
+				TTL:       ttl,
+				Publisher: kv.publisher,
+				Name:      kv.name,
+				Immutable: kv.immutable,
+				Erasure:   kv.erasure,
+				Cached:    kv.cached,
+			}
+			return nil
+		}
 	}
 
 	// Otherwise, return set of k triples (equiv. to FindNode)
-	toFindID := new(big.Int)
-	toFindID.SetString(args.Key, keyBase)
-	nearest := node.rt.findKNearestContacts(*toFindID)
+	nearest := node.rt.findKNearestContacts(toFindID)
 	*reply = FindValueReply{Contacts: nearest}
 	return nil
 }
 
 // FindNode is the handler for the FINDNODE RPC
-func (node *Node) FindNode(args FindNodeArgs, reply *FindNodeReply) error {
-	node.logger.Printf("FindNode from %s", args.Source.String())
+func (node *Node) FindNode(args FindNodeArgs, reply *FindNodeReply) (err error) {
+	defer func() { node.rpcReceived("FindNode", args.Source, err) }()
+	node.logs.rpc.Debug("FindNode", "from", args.Source.String())
 	contact := NewContact(args.Source)
 	if contact == nil {
 		return errors.New("Couldn't hash IP address")
 	}
-	node.rt.add(*contact)
+	node.rt.seen(*contact, 0)
 
-	keyInt := new(big.Int)
-	keyInt.SetString(args.Key, keyBase)
+	keyInt, err := ParseKey(args.Key)
+	if err != nil {
+		return err
+	}
 
-	nearest := node.rt.findKNearestContacts(*keyInt)
+	nearest := node.rt.findKNearestContacts(keyInt)
 	*reply = FindNodeReply{Contacts: nearest}
-	node.logger.Printf("Processed FindNode from %s", args.Source.String())
+	node.logs.rpc.Debug("Processed FindNode", "from", args.Source.String())
 	return nil
 }
 
 func (node *Node) String() string {
-	return fmt.Sprintf("Node: (id = %s) (address = %s) (kBuckets = %v)",
-		node.id.Text(keyBase),
+	// the size of each non-empty bucket, see GET /routing for the contacts
+	buckets := make([]string, 0)
+	for index, bucket := range node.rt.kBuckets {
+		if n := len(bucket.getAllContacts()); n > 0 {
+			buckets = append(buckets, fmt.Sprintf("%d:%d", index, n))
+		}
+	}
+	return fmt.Sprintf("Node: (id = %s) (address = %s) (kBuckets = %s)",
+		node.id,
 		node.addr.String(),
-		node.rt)
+		strings.Join(buckets, " "))
 }
 
 // Return XOR distance between node and other
-func (node *Node) distanceTo(other *Contact) *big.Int {
-	return big.NewInt(0).Xor(&node.id, &other.Id)
+func (node *Node) distanceTo(other *Contact) NodeID {
+	return node.id.Xor(other.Id)
 }
 
-func distanceBetween(firstID big.Int, secondID big.Int) *big.Int {
-	return big.NewInt(0).Xor(&firstID, &secondID)
+func distanceBetween(firstID NodeID, secondID NodeID) NodeID {
+	return firstID.Xor(secondID)
 }
 
-// NewNode returns a new Node struct
+// NewNode returns a new Node struct using DefaultConfig
 func NewNode(address string) *Node {
+	return NewNodeWithConfig(address, DefaultConfig())
+}
+
+// NewNodeWithConfig returns a new Node struct that applies config
+func NewNodeWithConfig(address string, config Config) *Node {
+	return NewNodeWithTransport(address, config, nil)
+}
+
+// NewNodeWithTransport returns a new Node struct that applies config and sends
+// its RPCs through transport, or over net/rpc if transport is nil
+func NewNodeWithTransport(address string, config Config, transport Transport) *Node {
 	node := new(Node)
+	node.config = config
+	node.transport = transport
+	if transport == nil {
+		node.transport = &rpcTransport{node}
+	}
 	addr, err := net.ResolveTCPAddr("tcp", address)
 	if err != nil {
 		fmt.Println(err)
@@ -183,43 +480,83 @@ func NewNode(address string) *Node {
 
 	node.addr = *addr
 
-	hash := sha1.Sum([]byte(addr.String()))
-
-	node.id = *big.NewInt(0)
-	node.id.SetBytes(hash[:])
+	node.id = NodeIDFromString(addr.String())
 	// TODO: take in k and tRefresh arguments - for now just hardcoding default
 	node.rt = NewRoutingTable(node)
+	node.metrics = newMetrics()
+	node.traces = new(traceRing)
+	node.started = time.Now()
 
-	// Disable logging if necessary (see option in globals.go)
-	if !loggingEnable {
-		log.SetOutput(ioutil.Discard)
-		log.SetFlags(0)
-		node.logger = log.New(ioutil.Discard, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	} else {
-		node.logger = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
+	// Log to stdout at the levels in config
+	node.logs, err = newLogs(os.Stdout, config.LogJSON, config.LogLevel, addr.String())
+	if err != nil {
+		fmt.Println(err)
+		return nil
 	}
 
 	node.ht = *NewKVStore()
 
-	fmt.Println(caching_on)
-
 	return node
 }
 
 // Run is called on an initialized Node to begin serving the RPC endpoints
+// It joins the network through the node at toPing, or starts a new one if
+// toPing is empty
 func (node *Node) Run(toPing string) {
+	var seeds []string
+	if toPing != "" {
+		seeds = []string{toPing}
+	}
+	node.RunWithSeeds(seeds)
+}
+
+// RunWithSeeds is Run, joining the network through seeds (see JoinSeeds) while
+// the endpoints are served. The process exits if the node can't join.
+func (node *Node) RunWithSeeds(seeds []string) {
 	nodeRPC := &NodeRPC{node}
 	rpc.Register(nodeRPC)
 	rpc.HandleHTTP()
 	node.setupControlEndpoints()
 
+	// open our own port for connection before joining, so that /healthz and
+	// /readyz answer while we bootstrap
+	l, e := net.ListenTCP("tcp", &node.addr)
+	if e != nil {
+		log.Fatal(e)
+		return
+	}
+
+	// replication doesn't wait for the join, which may retry for a long time
+	go node.replicate()
+	go func() {
+		if err := node.JoinSeeds(seeds); err != nil {
+			node.logs.routing.Errorf("%s", err)
+			os.Exit(1)
+		}
+
+		// write our address into the bootstrap node file
+		f, err := os.OpenFile(Bootstrap_node_path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if (err != nil) {
+			log.Fatal(err)
+		}
+		w := bufio.NewWriter(f)
+		fmt.Fprintln(w, node.addr.String())
+		w.Flush()
+		f.Close()
+	}()
+	http.Serve(l, nil)
+}
+
+// Join fills the routing table by looking up our own ID through the node at
+// toPing. An empty toPing starts a new network.
+func (node *Node) Join(toPing string) {
 	// if the node was passed a node to ping, otherwise
 	// don't bother
 	if toPing != "" {
 		toPingAddr, err := net.ResolveTCPAddr("", toPing)
-		//TODO: handle err
 		if err != nil {
-			node.logger.Printf("%s", err)
+			node.logs.routing.Warnf("%s", err)
+			return
 		}
 
 		contact := NewContact(*toPingAddr)
@@ -227,52 +564,50 @@ func (node *Node) Run(toPing string) {
 		node.rt.add(*contact)
 		// get k closest nodes and add to routing table by querying
 		// own id
-		kclosest := node.doIterativeFindNode(node.id.Text(keyBase))
+		kclosest := node.doIterativeFindNode(node.id.String())
 		for i := 0; i < len(kclosest); i++ {
 			curr := kclosest[i]
-			node.logger.Printf("Got node %s with ID %s", curr.Addr.String(), curr.Id.String())
+			node.logs.routing.Debugf("Got node %s with ID %s", curr.Addr.String(), curr.Id)
 			node.rt.add(curr)
 		}
 
-		// fill k buckets further away :w
-	}
-
-	node.logger.Printf("Finished routing table initialization")
-	// open our own port for connection
-	l, e := net.ListenTCP("tcp", &node.addr)
-	if e != nil {
-		log.Fatal(e)
-		return
+		// the seed is in the routing table whether or not it's alive, so
+		// we've only joined if someone answered the lookup
+		if node.rt.seenContacts() == 0 {
+			node.logs.routing.Warnf("Nobody answered the join through %s", toPing)
+			return
+		}
+		atomic.StoreInt32(&node.joinState, joined)
+	} else {
+		atomic.StoreInt32(&node.joinState, startedNetwork)
 	}
 
-	// write our address into the bootstrap node file
-	f, err := os.OpenFile(Bootstrap_node_path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if (err != nil) {
-		log.Fatal(err)
-	}
-	w := bufio.NewWriter(f)
-	fmt.Fprintln(w, node.addr.String())
-	w.Flush()
-	f.Close()
-	http.Serve(l, nil)
+	node.logs.routing.Infof("Finished routing table initialization")
 }
 
-// Perform the legwork of RPC invocation
-func (node *Node) doRPC(method string, dest net.TCPAddr, args interface{}, reply interface{}) bool {
-	node.logger.Printf("Sending %s RPC to %s", method, dest.String())
+// SetLogger sends the node's log records, as text, to the writer of logger
+func (node *Node) SetLogger(logger *log.Logger) {
+	node.logs.setOutput(logger.Writer(), false, node.addr.String())
+}
 
-	client, err := rpc.DialHTTP("tcp", dest.String())
-	if err != nil {
-		node.logger.Printf("Dial to %s failed: %s", dest.String(), err)
-		return false
-	}
+// SetLogLevel changes the levels the node logs at, as in Config.LogLevel
+func (node *Node) SetLogLevel(spec string) error {
+	return node.logs.setLevels(spec)
+}
 
-	err = client.Call(fmt.Sprintf("NodeRPC.%s", method), args, reply)
-	if err != nil {
-		node.logger.Printf("%s RPC to %s failed: %s", method, dest.String(), err)
+// Perform the legwork of RPC invocation through the node's transport
+// A node that answers is marked as recently seen in the routing table, and one
+// that doesn't is counted as failing and eventually removed from it
+func (node *Node) doRPC(method string, dest net.TCPAddr, args interface{}, reply interface{}) bool {
+	start := time.Now()
+	if !node.transport.Call(method, dest, args, reply) {
+		node.metrics.rpcSent(method, false, time.Since(start))
+		node.rt.failed(*NewContact(dest))
 		return false
 	}
-
+	rtt := time.Since(start)
+	node.metrics.rpcSent(method, true, rtt)
+	node.rt.seen(*NewContact(dest), rtt)
 	return true
 }
 
@@ -286,7 +621,7 @@ func (node *Node) doPing(dest net.TCPAddr) bool {
 		return false
 	}
 
-	node.logger.Printf("Got ping reply from %s", reply.Source.String())
+	node.logs.rpc.Debug("Got ping reply", "from", reply.Source.String())
 
 	// TODO: Update K-Buckets
 	contact := NewContact(reply.Source)
@@ -297,12 +632,133 @@ func (node *Node) doPing(dest net.TCPAddr) bool {
 
 // Send a STORE RPC for (key, value) to dest
 func (node *Node) doStore(key string, value []byte, dest net.TCPAddr) {
-	args := StoreArgs{node.addr, key, value}
+	node.sendStore(StoreArgs{Source: node.addr, Key: key, Val: value}, dest)
+}
+
+// Send a STORE RPC with args to dest, returning an error if the RPC failed or
+// dest rejected the value
+func (node *Node) sendStore(args StoreArgs, dest net.TCPAddr) error {
 	var reply StoreReply
 
 	if !node.doRPC("Store", dest, args, &reply) {
-		return
+		return fmt.Errorf("STORE RPC to %s failed", dest.String())
+	}
+	if reply.Err != "" {
+		node.logs.store.Warn("STORE rejected", "key", args.Key, "peer", dest.String(), "err", reply.Err)
+		return errors.New(reply.Err)
+	}
+	return nil
+}
+
+// Send a DELETE RPC with args to dest, returning an error if the RPC failed or
+// dest refused the delete
+func (node *Node) sendDelete(args DeleteArgs, dest net.TCPAddr) error {
+	var reply DeleteReply
+
+	if !node.doRPC("Delete", dest, args, &reply) {
+		return fmt.Errorf("DELETE RPC to %s failed", dest.String())
 	}
+	if reply.Err != "" {
+		node.logs.store.Warn("DELETE refused", "key", args.Key, "peer", dest.String(), "err", reply.Err)
+		return errors.New(reply.Err)
+	}
+	return nil
+}
+
+// publish stores args on the k closest nodes to args.Key, failing only if none
+// of them accept it
+func (node *Node) publish(args StoreArgs) error {
+	_, err := node.publishQuorum(args, 1)
+	return err
+}
+
+// publishQuorum stores args on the k closest nodes to args.Key and returns how
+// many accepted it, failing if that's fewer than quorum
+func (node *Node) publishQuorum(args StoreArgs, quorum int) (int, error) {
+	acks, err := node.doIterativeStore(args)
+	if acks < quorum {
+		if err == nil {
+			err = errors.New("no replies")
+		}
+		return acks, fmt.Errorf("stored on %d nodes, %d required: %s", acks, quorum, err)
+	}
+	return acks, nil
+}
+
+// IterativeStore stores val under key on the k closest nodes, and returns how
+// many accepted it
+func (node *Node) IterativeStore(key string, val []byte) (int, error) {
+	return node.publishQuorum(StoreArgs{
+		Source:    node.addr,
+		Key:       key,
+		Val:       val,
+		Published: time.Now(),
+	}, 1)
+}
+
+// IterativeFindValue looks up the value stored under key, returning nil if
+// there isn't one, and how the lookup went
+func (node *Node) IterativeFindValue(key string) ([]byte, LookupStats) {
+	var stats LookupStats
+	value := node.doIterativeFindValueStats(key, false, &stats)
+	return value, stats
+}
+
+// IterativeFindNode looks up the k closest nodes to id
+func (node *Node) IterativeFindNode(id string) []Contact {
+	return node.doIterativeFindNode(id)
+}
+
+// Replicate performs a single round of replication, as the node does every
+// tReplicate while it runs
+func (node *Node) Replicate() {
+	node.doReplicate()
+}
+
+// Leave hands every value we hold on to the k closest other nodes, so that they
+// survive us leaving the network. The node carries on serving requests until it
+// is stopped.
+func (node *Node) Leave() {
+	atomic.StoreInt32(&node.leaving, 1)
+	node.logs.store.Info("Leaving, handing off values")
+	node.doReplicate()
+}
+
+func (node *Node) isLeaving() bool {
+	return atomic.LoadInt32(&node.leaving) == 1
+}
+
+// Contacts returns every contact in the routing table
+func (node *Node) Contacts() []Contact {
+	return node.rt.contacts()
+}
+
+// Holds reports whether we hold a live copy of the value stored under key,
+// not counting copies cached by lookups
+func (node *Node) Holds(key string) bool {
+	kv, ok := node.ht.getKV(key)
+	return ok && !kv.tombstone && !kv.cached && !kv.expired()
+}
+
+// StoreName stores val in the DHT under the key for name (see NameKey), and
+// returns the key
+func (node *Node) StoreName(name string, val []byte, ttl time.Duration) (string, error) {
+	key := NameKey(name)
+	err := node.publish(StoreArgs{
+		Source:    node.addr,
+		Key:       key,
+		Val:       val,
+		Published: time.Now(),
+		TTL:       ttl,
+		Name:      name,
+	})
+	return key, err
+}
+
+// FindName looks up the value stored under name, returning nil if there isn't
+// one
+func (node *Node) FindName(name string) []byte {
+	return node.doIterativeFindValue(NameKey(name), false)
 }
 
 // Send a FINDVALUE RPC for key to dest
@@ -323,12 +779,16 @@ func (node *Node) doFindValue(key string, dest net.TCPAddr) *FindValueReply {
 }
 
 // Send a FINDNODE RPC for key to dest
+// Returns nil if the RPC failed, and a non-nil slice if it succeeded
 func (node *Node) doFindNode(nodeKey string, dest net.TCPAddr) []Contact {
 	args := FindNodeArgs{node.addr, nodeKey}
 	var reply FindNodeReply
 	if !node.doRPC("FindNode", dest, args, &reply) {
 		return nil
 	}
+	if reply.Contacts == nil {
+		reply.Contacts = []Contact{}
+	}
 
 	// Update K-Buckets
 	for _, contact := range reply.Contacts {
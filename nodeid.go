@@ -0,0 +1,109 @@
+package kademlia
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// NodeID is a 160-bit node ID or key. IDs are compared as big-endian numbers.
+type NodeID [sha1.Size]byte
+
+// NodeIDFromString returns the ID of the node at address, the SHA-1 hash of
+// the address
+func NodeIDFromString(address string) NodeID {
+	return NodeID(sha1.Sum([]byte(address)))
+}
+
+// Xor returns the XOR distance between id and other
+func (id NodeID) Xor(other NodeID) NodeID {
+	var dist NodeID
+	for i := range id {
+		dist[i] = id[i] ^ other[i]
+	}
+	return dist
+}
+
+// BitLen returns the number of bits needed to write id, 0 for the zero ID
+func (id NodeID) BitLen() int {
+	for i, b := range id {
+		if b != 0 {
+			return (len(id)-i-1)*8 + bits.Len8(b)
+		}
+	}
+	return 0
+}
+
+// Cmp returns -1, 0 or +1 as id is less than, equal to or greater than other
+func (id NodeID) Cmp(other NodeID) int {
+	return bytes.Compare(id[:], other[:])
+}
+
+// IsZero returns true for the zero ID
+func (id NodeID) IsZero() bool {
+	return id == NodeID{}
+}
+
+// String returns id as 40 lowercase hex digits
+func (id NodeID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText encodes id as hex, so it appears in JSON as a string
+func (id NodeID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText decodes an ID written by MarshalText, or any key ParseKey
+// accepts
+func (id *NodeID) UnmarshalText(text []byte) error {
+	parsed, err := ParseKey(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+// closerTo returns true if a is closer to target than b
+func closerTo(target NodeID, a NodeID, b NodeID) bool {
+	for i := range target {
+		if x, y := a[i]^target[i], b[i]^target[i]; x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+// ParseKey parses a key or node ID, which must be at most 160 bits of hex.
+// Keys shorter than 40 digits are read as if padded with leading zeros.
+func ParseKey(key string) (NodeID, error) {
+	var id NodeID
+	if key == "" {
+		return id, errors.New("key is empty")
+	}
+	if len(key) > 2*len(id) {
+		return id, fmt.Errorf("key %q is longer than %d hex digits", key, 2*len(id))
+	}
+	for _, c := range key {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return id, fmt.Errorf("key %q is not hex", key)
+		}
+	}
+	padded := key
+	if len(padded)%2 == 1 {
+		padded = "0" + padded
+	}
+	decoded, _ := hex.DecodeString(padded)
+	copy(id[len(id)-len(decoded):], decoded)
+	return id, nil
+}
+
+// keyID returns the ID of key, or the zero ID if key isn't valid
+func keyID(key string) NodeID {
+	id, _ := ParseKey(key)
+	return id
+}
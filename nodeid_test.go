@@ -0,0 +1,46 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"testing"
+	"testing/quick"
+)
+
+// TestCloserTo checks that closerTo orders IDs the same way as comparing
+// their XOR distances
+func TestCloserTo(t *testing.T) {
+	property := func(target NodeID, a NodeID, b NodeID) bool {
+		want := target.Xor(a).Cmp(target.Xor(b)) < 0
+		return closerTo(target, a, b) == want
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+	id := NodeIDFromString("10.0.0.1:4000")
+	if closerTo(id, id, id) {
+		t.Error("an ID is closer to itself than itself")
+	}
+}
+
+func TestNodeIDJSON(t *testing.T) {
+	contact := testContact(1)
+	data, err := json.Marshal(contact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Contact
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+	if decoded.Id != contact.Id {
+		t.Errorf("ID %s came back as %s", contact.Id, decoded.Id)
+	}
+
+	var id NodeID
+	if err := json.Unmarshal([]byte(`"1f"`), &id); err != nil || id != idBits(0, 1, 2, 3, 4) {
+		t.Errorf("short key decoded as %s, %v", id, err)
+	}
+	if err := json.Unmarshal([]byte(`"0x1f"`), &id); err == nil {
+		t.Error("decoded a key with a base prefix")
+	}
+}
@@ -0,0 +1,152 @@
+package kademlia
+
+import (
+	"sort"
+)
+
+// quorumResult is a FINDVALUE reply from one node in a quorum read
+type quorumResult struct {
+	contact Contact
+	reply   *FindValueReply
+}
+
+// newerThan returns true if a's value should win over b's
+// Mutable values are ordered by sequence number, everything else (including
+// tombstones) by publication or deletion time
+func (a *quorumResult) newerThan(b *quorumResult) bool {
+	if a.reply.Mutable != nil && b.reply.Mutable != nil {
+		return a.reply.Mutable.Seq > b.reply.Mutable.Seq
+	}
+	return a.reply.Published.After(b.reply.Published)
+}
+
+// Iteratively send FINDVALUE RPCs until quorum nodes have returned a value
+// (or said the key is deleted), or there is nobody left to ask
+// The newest value wins and is read-repaired onto the nodes closest to the key
+// that returned an older value or none at all
+func (node *Node) doQuorumFindValue(key string, quorum int) []byte {
+	toFindID := keyID(key)
+	byDistance := func(contacts []Contact) {
+		sort.Slice(contacts, func(i, j int) bool {
+			return closerTo(toFindID, contacts[i].Id, contacts[j].Id)
+		})
+	}
+
+	var results []*quorumResult
+	// nodes that had nothing for us, candidates for read repair
+	empty := make([]Contact, 0, k)
+
+	if kv, ok := node.ht.getKV(key); ok && !(kv.tombstone && kv.unverified) {
+		reply := new(FindValueReply)
+		node.FindValue(FindValueArgs{node.addr, key}, reply)
+		if reply.Val != nil || reply.Deleted {
+			results = append(results, &quorumResult{*NewContact(node.addr), reply})
+		}
+	}
+
+	contacted := make(map[string]bool)
+	contacted[node.addr.String()] = true
+	shortlist := node.rt.findKNearestContacts(toFindID)
+
+	for len(results) < quorum {
+		toSend := make([]Contact, 0, alpha)
+		for _, contact := range shortlist {
+			if len(toSend) == alpha {
+				break
+			}
+			if !contacted[contact.Addr.String()] {
+				contacted[contact.Addr.String()] = true
+				toSend = append(toSend, contact)
+			}
+		}
+		if len(toSend) == 0 {
+			break
+		}
+
+		resultChan := make(chan *quorumResult, len(toSend))
+		node.transport.Parallel(len(toSend), func(i int) {
+			resultChan <- &quorumResult{toSend[i], node.doFindValue(key, toSend[i].Addr)}
+		})
+		for i := 0; i < len(toSend); i++ {
+			res := <-resultChan
+			switch {
+			case res.reply == nil:
+				// unreachable, not worth repairing
+			case res.reply.Mutable != nil && res.reply.Mutable.verify(key, res.reply.Val) != nil:
+				node.logs.routing.Warnf("Discarding badly signed value from %s", res.contact.Addr.String())
+			case res.reply.Val != nil || res.reply.Deleted:
+				results = append(results, res)
+			default:
+				empty = append(empty, res.contact)
+				shortlist = append(shortlist, res.reply.Contacts...)
+			}
+		}
+
+		shortlist = RemoveDupesFromShortlist(shortlist)
+		byDistance(shortlist)
+		if len(shortlist) > k {
+			shortlist = shortlist[:k]
+		}
+	}
+
+	if len(results) == 0 {
+		return nil
+	}
+	newest := results[0]
+	for _, res := range results[1:] {
+		if res.newerThan(newest) {
+			newest = res
+		}
+	}
+	node.logs.routing.Debugf("Quorum read of %s got %d replies", key, len(results))
+	if newest.reply.Deleted {
+		return nil
+	}
+
+	// read repair the stale copies, and the nodes that had nothing if they're
+	// among the k closest we heard from
+	repair := make([]Contact, 0, k)
+	closest := append([]Contact{}, empty...)
+	for _, res := range results {
+		if newest.newerThan(res) {
+			repair = append(repair, res.contact)
+		}
+		closest = append(closest, res.contact)
+	}
+	byDistance(closest)
+	if len(closest) > k {
+		closest = closest[:k]
+	}
+	for _, contact := range closest {
+		for _, emptyContact := range empty {
+			if AreEqualContacts(&contact, &emptyContact) {
+				repair = append(repair, contact)
+			}
+		}
+	}
+
+	args := StoreArgs{
+		Source:    node.addr,
+		Key:       key,
+		Val:       newest.reply.Val,
+		Immutable: newest.reply.Immutable,
+		Mutable:   newest.reply.Mutable,
+		Published: newest.reply.Published,
+		OwnerHash: newest.reply.OwnerHash,
+		TTL:       newest.reply.TTL,
+		Publisher: newest.reply.Publisher,
+		Erasure:   newest.reply.Erasure,
+		Name:      newest.reply.Name,
+	}
+	for _, contact := range repair {
+		node.logs.routing.Infof("Read repairing %s on %s", key, contact.Addr.String())
+		dest := contact.Addr
+		if dest.String() == node.addr.String() {
+			node.transport.Go(func() { node.Store(args, &StoreReply{}) })
+		} else {
+			node.transport.Go(func() { node.sendStore(args, dest) })
+		}
+	}
+
+	return newest.reply.Val
+}
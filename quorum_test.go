@@ -0,0 +1,33 @@
+package kademlia
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuorumReadRepair(t *testing.T) {
+	nodes, _ := chain(t, 3)
+	nodes[0].rt.add(*NewContact(nodes[2].addr))
+	val := []byte("value")
+	stored := []*KV{
+		{key: ContentKey(val), val: val, immutable: true, erasure: true},
+		{key: NameKey("name"), val: val, name: "name"},
+	}
+
+	for _, want := range stored {
+		want.published = time.Now()
+		want.expires = time.Now().Add(time.Hour)
+		nodes[1].ht.put(want)
+		if got := nodes[0].doQuorumFindValue(want.key, 2); string(got) != "value" {
+			t.Fatalf("quorum read of %s returned %q", want.key, got)
+		}
+		// the node that had nothing gets the value, as it was stored
+		kv, ok := nodes[2].ht.getKV(want.key)
+		if !ok || string(kv.val) != "value" {
+			t.Fatalf("%s wasn't read repaired", want.key)
+		}
+		if kv.immutable != want.immutable || kv.erasure != want.erasure || kv.name != want.name {
+			t.Errorf("read repair stored immutable %t, erasure %t, name %q", kv.immutable, kv.erasure, kv.name)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package kademlia
+
+import (
+	"time"
+)
+
+// replicate runs for the lifetime of the node, passing every key we hold on to
+// the k closest nodes every tReplicate
+func (node *Node) replicate() {
+	ticker := time.NewTicker(tReplicate)
+	for range ticker.C {
+		node.doReplicate()
+	}
+}
+
+// doReplicate performs a single round of replication
+// Values are re-stored with their original publication time and whatever is
+// left of their TTL, and tombstones are re-sent so that a node that missed the
+// delete doesn't hand the value back out, unless we couldn't verify them. Expired values, and tombstones older
+// than tExpire, are dropped. Missing shards of erasure coded values we hold the
+// manifest for are rebuilt.
+func (node *Node) doReplicate() {
+	node.logs.store.Info("Starting replication")
+	for kv := range node.ht.Iterator() {
+		ttl := kv.ttl()
+		if !kv.tombstone && ttl <= 0 {
+			node.logs.store.Debug("Dropping expired key", "key", kv.key)
+			node.ht.removeIf(kv.key, func(current *KV) bool {
+				return !current.tombstone && current.expired()
+			})
+			continue
+		}
+
+		if kv.tombstone {
+			if time.Since(kv.deleted) > tExpire {
+				node.logs.store.Debug("Dropping tombstone", "key", kv.key)
+				node.ht.removeIf(kv.key, func(current *KV) bool {
+					return current.tombstone && !current.deleted.After(kv.deleted)
+				})
+				continue
+			}
+			// we can't vouch for a delete we never saw the value for, so
+			// it's left to the node that sent it
+			if kv.unverified {
+				continue
+			}
+			node.doIterativeDelete(DeleteArgs{
+				Source:  node.addr,
+				Key:     kv.key,
+				Secret:  kv.deleteSecret,
+				Mutable: kv.mutable,
+				Deleted: kv.deleted,
+			})
+			continue
+		}
+
+		// cached copies are left to expire
+		if kv.cached {
+			continue
+		}
+
+		node.doIterativeStore(StoreArgs{
+			Source:    node.addr,
+			Key:       kv.key,
+			Val:       kv.val,
+			Immutable: kv.immutable,
+			Mutable:   kv.mutable,
+			Published: kv.published,
+			OwnerHash: kv.ownerHash,
+			TTL:       ttl,
+			Publisher: kv.publisher,
+			Erasure:   kv.erasure,
+			Name:      kv.name,
+		})
+
+		if kv.erasure {
+			node.repairErasure(kv)
+		}
+	}
+}
@@ -4,12 +4,15 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"math/big"
+	"math"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func checkMethod(methods []string, request *http.Request, w http.ResponseWriter) bool {
@@ -19,10 +22,25 @@ func checkMethod(methods []string, request *http.Request, w http.ResponseWriter)
 		}
 	}
 
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	w.WriteHeader(http.StatusMethodNotAllowed)
 	fmt.Fprintf(w, "This endpoint only works with %s", strings.Join(methods[:], " "))
 	return false
 }
 
+// checkKey returns key written the way the node stores it, as 40 lower case
+// hex digits. It responds with 400 and returns false if key isn't a valid hex
+// key (see ParseKey).
+func checkKey(key string, w http.ResponseWriter) (string, bool) {
+	id, err := ParseKey(key)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid key: %s", err)
+		return "", false
+	}
+	return id.String(), true
+}
+
 func (node *Node) handlePingIP(w http.ResponseWriter, r *http.Request) {
 	if !checkMethod([]string{"GET"}, r, w) {
 		return
@@ -36,7 +54,7 @@ func (node *Node) handlePingIP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	node.logger.Printf("Performing IP PING of %s", addr)
+	node.logs.rest.Debugf("Performing IP PING of %s", addr)
 
 	if node.doPing(*addr) {
 		fmt.Fprintf(w, "Host %s successfully pinged", ipString)
@@ -52,19 +70,19 @@ func (node *Node) handlePingID(w http.ResponseWriter, r *http.Request) {
 
 	idString := r.URL.Path[len("/ping/id/"):]
 
-	id := new(big.Int)
-	_, success := id.SetString(idString, 16)
-	if !success {
-		fmt.Fprintf(w, "Invalid id: %s", idString)
+	id, err := ParseKey(idString)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid id: %s", err)
 		return
 	}
 
-	node.logger.Printf("Performing ID PING of %s", id.String())
+	node.logs.rest.Debugf("Performing ID PING of %s", id.String())
 
-	contact := node.rt.ContactFromID(*id)
+	contact := node.rt.ContactFromID(id)
 	if contact == nil {
 		fmt.Fprintf(w, "Could not find %s in routing table", id.String())
-		node.logger.Printf("Could not find %s in the routing table", id.String())
+		node.logs.rest.Debugf("Could not find %s in the routing table", id.String())
 		return
 	}
 
@@ -79,31 +97,195 @@ func (node *Node) handlePingID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ownerHashFromRequest returns the hash of the delete secret given in the
+// "secret" query parameter, or nil if there isn't one
+func ownerHashFromRequest(r *http.Request) []byte {
+	secret := r.URL.Query().Get("secret")
+	if secret == "" {
+		return nil
+	}
+	return OwnerHash([]byte(secret))
+}
+
+// ttlFromRequest parses the "ttl" query parameter, either a duration such as
+// "10m" or a number of seconds. It returns zero if there isn't one.
+func ttlFromRequest(r *http.Request) (time.Duration, error) {
+	return parseTTL(r.URL.Query().Get("ttl"))
+}
+
+// parseTTL parses a TTL given either as a duration such as "10m" or a number
+// of seconds. The empty string is zero.
+func parseTTL(ttlString string) (time.Duration, error) {
+	if ttlString == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseInt(ttlString, 10, 64); err == nil {
+		if seconds <= 0 || seconds > math.MaxInt64/int64(time.Second) {
+			return 0, fmt.Errorf("invalid ttl %s", ttlString)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	ttl, err := time.ParseDuration(ttlString)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid ttl %s", ttlString)
+	}
+	return ttl, nil
+}
+
+// readValue reads a value from the request body, refusing values larger than
+// the node's MaxValueSize without reading them in full
+func (node *Node) readValue(r *http.Request) ([]byte, error) {
+	return readLimited(r.Body, int64(node.config.MaxValueSize))
+}
+
+// tooLargeError is returned by readLimited for a body over its limit
+type tooLargeError struct {
+	limit int64
+}
+
+func (e tooLargeError) Error() string {
+	return fmt.Sprintf("value is larger than the %d byte limit", e.limit)
+}
+
+// readLimited reads body, giving up with a tooLargeError as soon as it has
+// read more than limit bytes. A limit of 0 or less reads the whole body.
+func readLimited(body io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return ioutil.ReadAll(body)
+	}
+	value, err := ioutil.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(value)) > limit {
+		return nil, tooLargeError{limit}
+	}
+	return value, nil
+}
+
+// requestPublisher returns the publisher that quotas are charged to for a REST
+// request, the client's host
+func requestPublisher(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func (node *Node) handleStore(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"POST", "DELETE"}, r, w) {
+		return
+	}
+	key, ok := checkKey(r.URL.Path[len("/store/"):], w)
+	if !ok {
+		return
+	}
+	if r.Method == "DELETE" {
+		node.handleDelete(w, r)
+		return
+	}
+
+	node.storeFromRequest(w, r, key, "")
+}
+
+func (node *Node) handleStoreName(w http.ResponseWriter, r *http.Request) {
 	if !checkMethod([]string{"POST"}, r, w) {
 		return
 	}
 
-	key := r.URL.Path[len("/store/"):]
-	value, err := ioutil.ReadAll(r.Body)
+	name := r.URL.Path[len("/name/store/"):]
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Name is empty")
+		return
+	}
+
+	node.storeFromRequest(w, r, NameKey(name), name)
+}
+
+// storeFromRequest publishes the value in the body of r under key, which was
+// derived from name if it isn't empty
+func (node *Node) storeFromRequest(w http.ResponseWriter, r *http.Request, key string, name string) {
+	ttl, err := ttlFromRequest(r)
 	if err != nil {
-		fmt.Fprintf(w, "Error reading value")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+	value, err := node.readValue(r)
+	if err != nil {
+		fmt.Fprintf(w, "Error reading value: %s", err)
+		return
+	}
+
+	quorum := 1
+	if quorumString := r.URL.Query().Get("quorum"); quorumString != "" {
+		quorum, err = strconv.Atoi(quorumString)
+		if err != nil || quorum < 1 || quorum > k {
+			fmt.Fprintf(w, "Invalid quorum %s, must be between 1 and %d", quorumString, k)
+			return
+		}
 	}
 
 	encoded := base64.StdEncoding.EncodeToString(value)
-	node.logger.Printf("Received REST STORE for key: (%s), value: (%s)", key, encoded)
+	node.logs.rest.Debugf("Received REST STORE for key: (%s), value: (%s)", key, encoded)
 
-	closest := node.doIterativeFindNode(key)
-	// TODO: Check that we have a node that is the closest
-	var storeHere net.TCPAddr
-	if len(closest) > 0 {
-		storeHere = closest[0].Addr
-	} else {
-		storeHere = node.addr
+	acks, err := node.publishQuorum(StoreArgs{
+		Source:    node.addr,
+		Key:       key,
+		Val:       value,
+		Published: time.Now(),
+		OwnerHash: ownerHashFromRequest(r),
+		TTL:       ttl,
+		Publisher: requestPublisher(r),
+		Name:      name,
+	}, quorum)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "Error storing key (%s): %s", key, err)
+		return
 	}
-	node.doStore(key, value, storeHere)
 
-	fmt.Fprintf(w, "Successfully stored key (%s)", key)
+	fmt.Fprintf(w, "Successfully stored key (%s) on %d nodes", key, acks)
+}
+
+func (node *Node) handleStoreImmutable(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"POST"}, r, w) {
+		return
+	}
+
+	ttl, err := ttlFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+	value, err := node.readValue(r)
+	if err != nil {
+		fmt.Fprintf(w, "Error reading value: %s", err)
+		return
+	}
+
+	key := ContentKey(value)
+	node.logs.rest.Debugf("Received REST immutable STORE for key: (%s)", key)
+
+	err = node.publish(StoreArgs{
+		Source:    node.addr,
+		Key:       key,
+		Val:       value,
+		Immutable: true,
+		Published: time.Now(),
+		OwnerHash: ownerHashFromRequest(r),
+		TTL:       ttl,
+		Publisher: requestPublisher(r),
+	})
+	if err != nil {
+		fmt.Fprintf(w, "Error storing key (%s): %s", key, err)
+		return
+	}
+
+	fmt.Fprintf(w, "%s", key)
 }
 
 func (node *Node) handleStoreHere(w http.ResponseWriter, r *http.Request) {
@@ -111,20 +293,303 @@ func (node *Node) handleStoreHere(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	key := r.URL.Path[len("/store_here/"):]
-	value, err := ioutil.ReadAll(r.Body)
+	key, ok := checkKey(r.URL.Path[len("/store_here/"):], w)
+	if !ok {
+		return
+	}
+	ttl, err := ttlFromRequest(r)
 	if err != nil {
-		fmt.Fprintf(w, "Error reading value")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+	value, err := node.readValue(r)
+	if err != nil {
+		fmt.Fprintf(w, "Error reading value: %s", err)
+		return
 	}
 
 	encoded := base64.StdEncoding.EncodeToString(value)
-	node.logger.Printf("Received STORE_HERE for key: (%s), value: (%s)", key, encoded)
+	node.logs.rest.Debugf("Received STORE_HERE for key: (%s), value: (%s)", key, encoded)
 
-	node.ht.add(key, value, true)
+	now := time.Now()
+	kv := &KV{
+		key:       key,
+		val:       value,
+		isOrigin:  true,
+		published: now,
+		ownerHash: ownerHashFromRequest(r),
+		expires:   now.Add(node.config.capTTL(ttl)),
+		publisher: requestPublisher(r),
+	}
+	err = node.ht.update(key, func(existing *KV) (*KV, error) {
+		return kv, node.ht.checkLimits(&node.config, existing, kv)
+	})
+	if err != nil {
+		fmt.Fprintf(w, "Error storing key (%s): %s", key, err)
+		return
+	}
 
 	fmt.Fprintf(w, "Successfully stored key (%s)", key)
 }
 
+// handleDelete deletes a key from the DHT, leaving tombstones on the k closest
+// nodes. Plain values are deleted with the secret they were stored with, given
+// as the "secret" query parameter. Mutable values need a JSON body with a
+// signed empty value at a higher sequence number.
+func (node *Node) handleDelete(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Path[len("/store/"):]
+	node.logs.rest.Debugf("Received REST DELETE for key: (%s)", key)
+
+	args := DeleteArgs{Source: node.addr, Key: key, Deleted: time.Now()}
+	if secret := r.URL.Query().Get("secret"); secret != "" {
+		args.Secret = []byte(secret)
+	} else {
+		var signed mutableJSON
+		if err := json.NewDecoder(r.Body).Decode(&signed); err != nil {
+			fmt.Fprintf(w, "Delete needs a secret or a signed record: %s", err)
+			return
+		}
+		args.Mutable = &MutableRecord{PublicKey: signed.PublicKey, Salt: signed.Salt, Seq: signed.Seq, Sig: signed.Sig}
+	}
+
+	acks := node.doIterativeDelete(args)
+	if acks == 0 {
+		fmt.Fprintf(w, "Could not delete key (%s)", key)
+		return
+	}
+
+	fmt.Fprintf(w, "Deleted key (%s) on %d nodes", key, acks)
+}
+
+// mutableJSON is the REST representation of a mutable value. Byte fields are
+// base64 encoded.
+type mutableJSON struct {
+	Key       string `json:"key,omitempty"`
+	PublicKey []byte `json:"public_key"`
+	Salt      []byte `json:"salt,omitempty"`
+	Seq       int64  `json:"seq"`
+	Cas       *int64 `json:"cas,omitempty"`
+	Sig       []byte `json:"sig"`
+	Value     []byte `json:"value"`
+}
+
+func (node *Node) handlePutMutable(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"POST"}, r, w) {
+		return
+	}
+
+	ttl, err := ttlFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+	// values are base64 encoded in the JSON body
+	if node.config.MaxValueSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(node.config.MaxValueSize)*2+4096)
+	}
+	var put mutableJSON
+	if err := json.NewDecoder(r.Body).Decode(&put); err != nil {
+		fmt.Fprintf(w, "Error reading mutable value: %s", err)
+		return
+	}
+
+	record := &MutableRecord{PublicKey: put.PublicKey, Salt: put.Salt, Seq: put.Seq, Sig: put.Sig}
+	key := MutableKey(put.PublicKey, put.Salt)
+	if err := record.verify(key, put.Value); err != nil {
+		fmt.Fprintf(w, "Invalid mutable value: %s", err)
+		return
+	}
+	node.logs.rest.Debugf("Received REST mutable PUT for key: (%s), seq: %d", key, put.Seq)
+
+	err = node.publish(StoreArgs{
+		Source:    node.addr,
+		Key:       key,
+		Val:       put.Value,
+		Mutable:   record,
+		Cas:       put.Cas,
+		Published: time.Now(),
+		TTL:       ttl,
+		Publisher: requestPublisher(r),
+	})
+	if err != nil {
+		fmt.Fprintf(w, "Error storing key (%s): %s", key, err)
+		return
+	}
+
+	fmt.Fprintf(w, "%s", key)
+}
+
+func (node *Node) handleGetMutable(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"GET"}, r, w) {
+		return
+	}
+
+	key, ok := checkKey(r.URL.Path[len("/mutable/get/"):], w)
+	if !ok {
+		return
+	}
+	node.logs.rest.Debugf("Node got REST mutable GET request for ID %s", key)
+
+	enc := json.NewEncoder(w)
+	value, record := node.doIterativeGetMutable(key)
+	if record == nil {
+		node.logs.rest.Warnf("ERROR with REST mutable GET request for ID %s", key)
+		enc.Encode(nil)
+		return
+	}
+	enc.Encode(mutableJSON{
+		Key:       key,
+		PublicKey: record.PublicKey,
+		Salt:      record.Salt,
+		Seq:       record.Seq,
+		Sig:       record.Sig,
+		Value:     value,
+	})
+}
+
+// handleStoreBlob stores a large value, streamed from the request body in
+// chunks, and responds with the manifest key
+func (node *Node) handleStoreBlob(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"POST"}, r, w) {
+		return
+	}
+
+	ttl, err := ttlFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+	node.logs.rest.Debugf("Received REST blob STORE")
+
+	key, err := node.storeLarge(r.Body, StoreArgs{
+		Source:    node.addr,
+		Published: time.Now(),
+		OwnerHash: ownerHashFromRequest(r),
+		TTL:       ttl,
+		Publisher: requestPublisher(r),
+	})
+	if err != nil {
+		fmt.Fprintf(w, "Error storing blob: %s", err)
+		return
+	}
+
+	fmt.Fprintf(w, "%s", key)
+}
+
+// handleFetchBlob streams the large value with the given manifest key
+func (node *Node) handleFetchBlob(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"GET"}, r, w) {
+		return
+	}
+
+	key, ok := checkKey(r.URL.Path[len("/blob/"):], w)
+	if !ok {
+		return
+	}
+	node.logs.rest.Debugf("Node got REST blob request for ID %s", key)
+
+	manifest, err := node.fetchManifest(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(manifest.Size, 10))
+	if err := node.fetchChunks(manifest, w); err != nil {
+		// too late to report it, the client sees a short body
+		node.logs.rest.Warnf("ERROR with REST blob request for ID %s: %s", key, err)
+	}
+}
+
+// handleStoreErasure erasure codes the request body and responds with the
+// manifest key. The n and m query parameters override the node's defaults.
+func (node *Node) handleStoreErasure(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"POST"}, r, w) {
+		return
+	}
+
+	ttl, err := ttlFromRequest(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+	var n, m int
+	if r.URL.Query().Get("n") != "" || r.URL.Query().Get("m") != "" {
+		n, err = strconv.Atoi(r.URL.Query().Get("n"))
+		if err == nil {
+			m, err = strconv.Atoi(r.URL.Query().Get("m"))
+		}
+		if err != nil {
+			fmt.Fprintf(w, "Both n and m must be given as integers")
+			return
+		}
+	}
+	// each of the m data shards can be up to MaxValueSize, so that's all we
+	// read
+	shards, dataShards := n, m
+	if n == 0 && m == 0 {
+		shards, dataShards = node.config.ErasureShards, node.config.ErasureDataShards
+	}
+	if _, err := newReedSolomon(shards, dataShards); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "%s", err)
+		return
+	}
+	value, err := readLimited(r.Body, int64(node.config.MaxValueSize)*int64(dataShards))
+	if _, ok := err.(tooLargeError); ok {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprintf(w, "Error reading value: %s", err)
+		return
+	} else if err != nil {
+		fmt.Fprintf(w, "Error reading value")
+		return
+	}
+	node.logs.rest.Debugf("Received REST erasure STORE of %d bytes", len(value))
+
+	key, err := node.storeErasure(value, n, m, StoreArgs{
+		Source:    node.addr,
+		Published: time.Now(),
+		OwnerHash: ownerHashFromRequest(r),
+		TTL:       ttl,
+		Publisher: requestPublisher(r),
+	})
+	if err != nil {
+		fmt.Fprintf(w, "Error storing value: %s", err)
+		return
+	}
+
+	fmt.Fprintf(w, "%s", key)
+}
+
+// handleFetchErasure rebuilds the erasure coded value with the given manifest
+// key and responds with it raw
+func (node *Node) handleFetchErasure(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"GET"}, r, w) {
+		return
+	}
+
+	key, ok := checkKey(r.URL.Path[len("/erasure/"):], w)
+	if !ok {
+		return
+	}
+	node.logs.rest.Debugf("Node got REST erasure request for ID %s", key)
+
+	value, err := node.FetchErasure(key)
+	if err != nil {
+		node.logs.rest.Warnf("ERROR with REST erasure request for ID %s: %s", key, err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(value)
+}
+
 func (node *Node) handleGetTable(w http.ResponseWriter, r *http.Request) {
 	if !checkMethod([]string{"GET"}, r, w) {
 		return
@@ -137,8 +602,20 @@ func (node *Node) handleGetTable(w http.ResponseWriter, r *http.Request) {
 		d := make(map[string]interface{})
 		d["key"] = val.key
 		d["value"] = val.val
-		fmt.Println(val.val)
 		d["isOrigin"] = val.isOrigin
+		d["cached"] = val.cached
+		d["publisher"] = val.publisher
+		d["deleted"] = val.tombstone
+		if !val.tombstone {
+			d["ttl"] = int64(val.ttl().Seconds())
+		}
+		d["immutable"] = val.immutable
+		if val.name != "" {
+			d["name"] = val.name
+		}
+		if val.mutable != nil {
+			d["seq"] = val.mutable.Seq
+		}
 		a = append(a, d)
 	}
 
@@ -146,17 +623,86 @@ func (node *Node) handleGetTable(w http.ResponseWriter, r *http.Request) {
 	enc.Encode(a)
 }
 
+// handleRouting reports the routing table, or the contacts in it closest to an
+// ID as used to answer FINDNODE
+func (node *Node) handleRouting(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"GET"}, r, w) {
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path == "/routing" {
+		writeJSON(w, http.StatusOK, node.routingJSON())
+		return
+	}
+
+	if !strings.HasPrefix(path, "/routing/closest/") {
+		writeError(w, http.StatusNotFound, "not_found", "no such endpoint %s", r.URL.Path)
+		return
+	}
+	id, ok := parseID(w, path[len("/routing/closest/"):])
+	if !ok {
+		return
+	}
+	closest := node.rt.findKNearestContacts(id)
+	entries := make([]routingEntry, len(closest))
+	for i, contact := range closest {
+		entries[i] = node.rt.entry(contact)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":       id.String(),
+		"contacts": routingEntriesToJSON(entries),
+	})
+}
+
+// oneshotTarget returns the node a oneshot request should be sent to, given
+// by the "node" query parameter
+func oneshotTarget(w http.ResponseWriter, r *http.Request) (*net.TCPAddr, bool) {
+	target := r.URL.Query().Get("node")
+	if target == "" {
+		fmt.Fprintf(w, "Oneshot requests need a node=<ip:port> parameter")
+		return nil, false
+	}
+	addr, err := net.ResolveTCPAddr("", target)
+	if err != nil {
+		fmt.Fprintf(w, "Couldn't resolve IP address %s: %s", target, err)
+		return nil, false
+	}
+	return addr, true
+}
+
+// oneshotFindValueJSON is the REST representation of a FINDVALUE reply
+type oneshotFindValueJSON struct {
+	Value    []byte    `json:"value"`
+	Contacts []Contact `json:"contacts"`
+	Seq      *int64    `json:"seq,omitempty"`
+	Deleted  bool      `json:"deleted,omitempty"`
+}
+
 func (node *Node) handleOneshotFindNode(w http.ResponseWriter, r *http.Request) {
 	if !checkMethod([]string{"GET"}, r, w) {
 		return
 	}
 
-	id := r.URL.Path[len("/oneshot/findnode/"):]
+	id, ok := checkKey(r.URL.Path[len("/oneshot/findnode/"):], w)
+	if !ok {
+		return
+	}
+	addr, ok := oneshotTarget(w, r)
+	if !ok {
+		return
+	}
+	node.logs.rest.Debugf("Node got REST oneshot FindNode request for ID %s via %s", id, addr)
+
+	args := FindNodeArgs{node.addr, id}
+	var reply FindNodeReply
+	if !node.doRPC("FindNode", *addr, args, &reply) {
+		fmt.Fprintf(w, "FINDNODE RPC to %s failed", addr)
+		return
+	}
 
-	// TODO: Check for valid id
-	// TODO: Perform necessary stuff
-	// TODO: Send back response
-	fmt.Fprintf(w, "Called FINDNODE for server %s", id)
+	enc := json.NewEncoder(w)
+	enc.Encode(reply.Contacts)
 }
 
 func (node *Node) handleOneshotFindValue(w http.ResponseWriter, r *http.Request) {
@@ -164,23 +710,51 @@ func (node *Node) handleOneshotFindValue(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	key := r.URL.Path[len("/oneshot/findvalue/"):]
+	key, ok := checkKey(r.URL.Path[len("/oneshot/findvalue/"):], w)
+	if !ok {
+		return
+	}
+	addr, ok := oneshotTarget(w, r)
+	if !ok {
+		return
+	}
+	node.logs.rest.Debugf("Node got REST oneshot FindValue request for ID %s via %s", key, addr)
+
+	args := FindValueArgs{node.addr, key}
+	var reply FindValueReply
+	if !node.doRPC("FindValue", *addr, args, &reply) {
+		fmt.Fprintf(w, "FINDVALUE RPC to %s failed", addr)
+		return
+	}
 
-	// TODO: Check for valid key
-	// TODO: Perform necessary stuff
-	// TODO: Send back response
-	fmt.Fprintf(w, "Called FINDVALUE for value %s", key)
+	result := oneshotFindValueJSON{Value: reply.Val, Contacts: reply.Contacts, Deleted: reply.Deleted}
+	if reply.Mutable != nil {
+		result.Seq = &reply.Mutable.Seq
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(result)
 }
 
 func (node *Node) handleIterativeFindNode(w http.ResponseWriter, r *http.Request) {
 	if !checkMethod([]string{"GET"}, r, w) {
 		return
 	}
-	id := r.URL.Path[len("/iterative/findnode/"):]
-	node.logger.Printf("Node got REST FindNode request for ID %s", id)
+	id, ok := checkKey(r.URL.Path[len("/iterative/findnode/"):], w)
+	if !ok {
+		return
+	}
+	node.logs.rest.Debugf("Node got REST FindNode request for ID %s", id)
 
-	contacts := node.doIterativeFindNode(id)
+	var stats LookupStats
+	if traceRequested(r) {
+		stats.Trace = new(LookupTrace)
+	}
+	contacts := node.doIterativeFindNodeStats(id, &stats)
 	enc := json.NewEncoder(w)
+	if stats.Trace != nil {
+		enc.Encode(map[string]interface{}{"contacts": contacts, "trace": stats.Trace})
+		return
+	}
 	enc.Encode(contacts)
 }
 
@@ -189,24 +763,90 @@ func (node *Node) handleIterativeFindValue(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	key := r.URL.Path[len("/iterative/findvalue/"):]
-	node.logger.Printf("Node got REST FindValue request for ID %s", key)
+	key, ok := checkKey(r.URL.Path[len("/iterative/findvalue/"):], w)
+	if !ok {
+		return
+	}
+	node.logs.rest.Debugf("Node got REST FindValue request for ID %s", key)
 
-	value := node.doIterativeFindValue(key)
+	quorum := 1
+	if quorumString := r.URL.Query().Get("r"); quorumString != "" {
+		var err error
+		quorum, err = strconv.Atoi(quorumString)
+		if err != nil || quorum < 1 || quorum > k {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid r %s, must be between 1 and %d", quorumString, k)
+			return
+		}
+	}
+	var stats LookupStats
+	if traceRequested(r) {
+		if quorum > 1 {
+			fmt.Fprintf(w, "Quorum reads can't be traced")
+			return
+		}
+		stats.Trace = new(LookupTrace)
+	}
+
+	var value []byte
+	if quorum > 1 {
+		value = node.doQuorumFindValue(key, quorum)
+	} else {
+		value = node.doIterativeFindValueStats(key, false, &stats)
+	}
 	if value == nil {
-		node.logger.Printf("ERROR with REST FindValue request for ID %s", key)
+		node.logs.rest.Warnf("ERROR with REST FindValue request for ID %s", key)
 	}
 	enc := json.NewEncoder(w)
+	if stats.Trace != nil {
+		enc.Encode(map[string]interface{}{"value": value, "trace": stats.Trace})
+		return
+	}
 	enc.Encode(value)
 
 }
 
+func (node *Node) handleFindName(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"GET"}, r, w) {
+		return
+	}
+
+	name := r.URL.Path[len("/name/findvalue/"):]
+	node.logs.rest.Debugf("Node got REST FindValue request for name %q", name)
+
+	value := node.FindName(name)
+	if value == nil {
+		node.logs.rest.Warnf("ERROR with REST FindValue request for name %q", name)
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(value)
+}
+
+func (node *Node) handleFindImmutable(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"GET"}, r, w) {
+		return
+	}
+
+	key, ok := checkKey(r.URL.Path[len("/immutable/findvalue/"):], w)
+	if !ok {
+		return
+	}
+	node.logs.rest.Debugf("Node got REST immutable FindValue request for ID %s", key)
+
+	value := node.doIterativeFindValue(key, true)
+	if value == nil {
+		node.logs.rest.Warnf("ERROR with REST immutable FindValue request for ID %s", key)
+	}
+	enc := json.NewEncoder(w)
+	enc.Encode(value)
+}
+
 func (node *Node) handleShutdown(w http.ResponseWriter, r *http.Request) {
 	if !checkMethod([]string{"GET"}, r, w) {
 		return
 	}
 
-	node.logger.Println("Shutdown received. Terminating")
+	node.logs.rest.Infof("Shutdown received. Terminating")
 
 	fmt.Fprintf(w, "Called SHUTDOWN")
 
@@ -215,10 +855,38 @@ func (node *Node) handleShutdown(w http.ResponseWriter, r *http.Request) {
 
 // setupControlEndpoints registers handlers for the remote control REST API
 func (node *Node) setupControlEndpoints() {
+	// The node's info (see /info), and 404 for any path nothing else handles
+	// GET /
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintf(w, "")
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		node.handleInfo(w, r)
+	})
+
+	// 200 whenever the node is serving
+	// GET /healthz
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		node.handleHealthz(w, r)
+	})
+
+	// 200 once the node has joined the network and its routing table holds
+	// at least -min-contacts contacts, 503 until then
+	// GET /readyz
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		node.handleReadyz(w, r)
 	})
 
+	// The node's ID, address, version, uptime, config and key count
+	// GET /info
+	http.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		node.handleInfo(w, r)
+	})
+
+	// The JSON API lives under /v1/, see rest_v1.go
+	node.setupV1Endpoints()
+
 	// Handle request to ping a specific server by IP address
 	// GET /ping/ip/<ip addr>
 	http.HandleFunc("/ping/ip/", func(w http.ResponseWriter, r *http.Request) {
@@ -233,7 +901,7 @@ func (node *Node) setupControlEndpoints() {
 
 	// Handle request to store (key,value) in the DHT
 	// This node becomes the originator
-	// POST /store_here/<key>
+	// POST /store_here/<key>[?ttl=<duration or seconds>]
 	// Body is raw value
 	http.HandleFunc("/store_here/", func(w http.ResponseWriter, r *http.Request) {
 		node.handleStoreHere(w, r)
@@ -241,40 +909,156 @@ func (node *Node) setupControlEndpoints() {
 
 	// Handle request to store (key,value) in the DHT
 	// This node becomes the originator
-	// POST /store/<key>
+	// POST /store/<key>[?secret=<secret>][&ttl=<duration or seconds>][&quorum=<acks>]
 	// Body is raw value
+	// The value is stored on the k closest nodes, and the request fails if
+	// fewer than quorum (default 1) of them accept it
+	//
+	// Handle request to delete key from the DHT
+	// DELETE /store/<key>?secret=<secret>
 	http.HandleFunc("/store/", func(w http.ResponseWriter, r *http.Request) {
 		node.handleStore(w, r)
 	})
 
+	// Handle request to store a value under a human-readable name, which is
+	// hashed to get the key (see NameKey)
+	// POST /name/store/<name>[?secret=<secret>][&ttl=<duration or seconds>][&quorum=<acks>]
+	// Body is raw value
+	http.HandleFunc("/name/store/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleStoreName(w, r)
+	})
+
+	// Handle iterative request to find the value stored under a name
+	// GET /name/findvalue/<name>
+	http.HandleFunc("/name/findvalue/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleFindName(w, r)
+	})
+
+	// Handle request to store a content-addressed value in the DHT
+	// The key is the hash of the value and is returned in the response
+	// POST /immutable/store
+	// Body is raw value
+	http.HandleFunc("/immutable/store", func(w http.ResponseWriter, r *http.Request) {
+		node.handleStoreImmutable(w, r)
+	})
+
+	// Handle iterative request to find a content-addressed value, ignoring
+	// copies that don't hash to the key
+	// GET /immutable/findvalue/<key>
+	http.HandleFunc("/immutable/findvalue/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleFindImmutable(w, r)
+	})
+
+	// Handle request to put a signed mutable value in the DHT
+	// POST /mutable/put
+	// Body is JSON: {"public_key", "salt", "seq", "cas", "sig", "value"}, with
+	// byte fields base64 encoded
+	http.HandleFunc("/mutable/put", func(w http.ResponseWriter, r *http.Request) {
+		node.handlePutMutable(w, r)
+	})
+
+	// Handle request for the newest version of a mutable value
+	// GET /mutable/get/<key>
+	http.HandleFunc("/mutable/get/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleGetMutable(w, r)
+	})
+
+	// Handle request to store a large value, split into chunks
+	// POST /blob[?secret=<secret>][&ttl=<duration or seconds>]
+	// Body is raw value, response is the manifest key
+	http.HandleFunc("/blob", func(w http.ResponseWriter, r *http.Request) {
+		node.handleStoreBlob(w, r)
+	})
+
+	// Handle request to fetch a large value by its manifest key
+	// GET /blob/<key>
+	http.HandleFunc("/blob/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleFetchBlob(w, r)
+	})
+
+	// Handle request to store an erasure coded value
+	// POST /erasure[?n=<shards>&m=<data shards>][&secret=<secret>][&ttl=<duration or seconds>]
+	// Body is raw value, response is the manifest key
+	http.HandleFunc("/erasure", func(w http.ResponseWriter, r *http.Request) {
+		node.handleStoreErasure(w, r)
+	})
+
+	// Handle request to fetch an erasure coded value by its manifest key
+	// GET /erasure/<key>
+	http.HandleFunc("/erasure/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleFetchErasure(w, r)
+	})
+
 	http.HandleFunc("/table", func(w http.ResponseWriter, r *http.Request) {
 		node.handleGetTable(w, r)
 	})
 
-	// Handle oneshot request to find node with specific node id
-	// GET /find/<id>
+	// The routing table: each non-empty bucket's ID range, its contacts with
+	// when they were last seen, their failures in a row and round trip time,
+	// and its replacement cache
+	// GET /routing
+	// The k contacts in the routing table closest to <id>, closest first
+	// GET /routing/closest/<id>
+	http.HandleFunc("/routing", func(w http.ResponseWriter, r *http.Request) {
+		node.handleRouting(w, r)
+	})
+	http.HandleFunc("/routing/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleRouting(w, r)
+	})
+
+	// Handle oneshot request to ask a single node for the nodes it knows
+	// closest to id
+	// GET /oneshot/findnode/<id>?node=<ip:port>
 	http.HandleFunc("/oneshot/findnode/", func(w http.ResponseWriter, r *http.Request) {
 		node.handleOneshotFindNode(w, r)
 	})
 
-	// Handle oneshot request to find specific value
-	// GET /findvalue/<key>
+	// Handle oneshot request to ask a single node for a specific value
+	// GET /oneshot/findvalue/<key>?node=<ip:port>
 	http.HandleFunc("/oneshot/findvalue/", func(w http.ResponseWriter, r *http.Request) {
 		node.handleOneshotFindValue(w, r)
 	})
 
 	// Handle iterative request to find node with specific node id
-	// GET /find/<id>
+	// GET /find/<id>[?trace=1]
+	// With trace=1 the reply is {"contacts": ..., "trace": ...}, see LookupTrace
 	http.HandleFunc("/iterative/findnode/", func(w http.ResponseWriter, r *http.Request) {
 		node.handleIterativeFindNode(w, r)
 	})
 
 	// Handle iterative request to find specific value
-	// GET /findvalue/<key>
+	// GET /findvalue/<key>[?r=<replies>][?trace=1]
+	// With r > 1 the lookup carries on until r nodes have returned the value,
+	// returns the newest and repairs stale copies
+	// With trace=1 the reply is {"value": ..., "trace": ...}, see LookupTrace
 	http.HandleFunc("/iterative/findvalue/", func(w http.ResponseWriter, r *http.Request) {
 		node.handleIterativeFindValue(w, r)
 	})
 
+	// Log level of each subsystem. PUT a list of levels such as
+	// "warn,routing=debug" to change them
+	// GET, PUT /admin/log
+	http.HandleFunc("/admin/log", func(w http.ResponseWriter, r *http.Request) {
+		node.handleAdminLog(w, r)
+	})
+
+	// The most recent traced lookups, newest first, or the one with <id>.
+	// Lookups are traced if asked to with ?trace=1, or with -trace-lookups
+	// GET /traces
+	// GET /traces/<id>
+	http.HandleFunc("/traces", func(w http.ResponseWriter, r *http.Request) {
+		node.handleTraces(w, r)
+	})
+	http.HandleFunc("/traces/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleTraces(w, r)
+	})
+
+	// Counters, histograms and gauges in the Prometheus text format
+	// GET /metrics
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		node.handleMetrics(w, r)
+	})
+
 	// Handle request to shutdown server
 	// GET /shutdown
 	http.HandleFunc("/shutdown", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,236 @@
+package kademlia
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{"", false},
+		{"0", true},
+		{"a", true},
+		{"DEADBEEF", true},
+		{strings.Repeat("f", 40), true},
+		{strings.Repeat("f", 41), false},
+		{strings.Repeat("0", 40), true},
+		{"0x1f", false},
+		{"-1", false},
+		{"+1", false},
+		{"1f ", false},
+		{" 1f", false},
+		{"12g4", false},
+		{"1_000", false},
+		{"name", false},
+		{"1f/2", false},
+	}
+	for _, test := range tests {
+		id, err := ParseKey(test.key)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseKey(%q) error = %v, want ok %t", test.key, err, test.ok)
+			continue
+		}
+		if test.ok && id.String() != padKey(test.key) {
+			t.Errorf("ParseKey(%q) = %s", test.key, id)
+		}
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		ttl  string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, true},
+		{"60", time.Minute, true},
+		{"10m", 10 * time.Minute, true},
+		{"0", 0, false},
+		{"-5", 0, false},
+		{"0s", 0, false},
+		{"-1h", 0, false},
+		{"9223372036", 9223372036 * time.Second, true},
+		{"9223372037", 0, false},
+		{"4611686018427387904", 0, false},
+		{"soon", 0, false},
+	}
+	for _, test := range tests {
+		ttl, err := parseTTL(test.ttl)
+		if (err == nil) != test.ok || ttl != test.want {
+			t.Errorf("parseTTL(%q) = %s, %v", test.ttl, ttl, err)
+		}
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	w := httptest.NewRecorder()
+	if key, ok := checkKey("00FF", w); !ok || key != padKey("ff") {
+		t.Errorf("checkKey(\"00FF\") = %q, %t", key, ok)
+	}
+	if key, ok := parseKey(w, "Ff"); !ok || key != padKey("ff") {
+		t.Errorf("parseKey(\"Ff\") = %q, %t", key, ok)
+	}
+	if _, ok := checkKey("0x10", w); ok || w.Code != http.StatusBadRequest {
+		t.Errorf("checkKey accepted a bad key, responding %d", w.Code)
+	}
+}
+
+// padKey returns key in lower case with leading zeros up to 40 digits
+func padKey(key string) string {
+	return strings.Repeat("0", 40-len(key)) + strings.ToLower(key)
+}
+
+// FuzzParseKey checks that any key ParseKey accepts is a 160-bit number that
+// is written the same way, apart from case and leading zeros, and that the
+// node treats every way of writing it as the same key
+func FuzzParseKey(f *testing.F) {
+	for _, seed := range []string{"", "0", "00ff", "FF", ContentKey([]byte("value")), "0x10", "-5", "g", strings.Repeat("f", 41)} {
+		f.Add(seed)
+	}
+	node := newTestNode(f, "10.0.0.1:4000")
+	source := testContact(1).Addr
+	f.Fuzz(func(t *testing.T, key string) {
+		id, err := ParseKey(key)
+		if err != nil {
+			return
+		}
+		if id.String() != padKey(key) {
+			t.Fatalf("ParseKey(%q) = %s", key, id)
+		}
+
+		args := StoreArgs{Source: source, Key: key, Val: []byte(key), Published: time.Now()}
+		if err := node.Store(args, &StoreReply{}); err != nil {
+			t.Fatalf("STORE of %q failed: %s", key, err)
+		}
+		var reply FindValueReply
+		node.FindValue(FindValueArgs{source, id.String()}, &reply)
+		if string(reply.Val) != key {
+			t.Fatalf("value stored under %q is %q under %s", key, reply.Val, id)
+		}
+	})
+}
+
+// restRoutes are the endpoints that parse a key, ID or name from their path
+var restRoutes = []struct {
+	prefix  string
+	method  string
+	handler func(node *Node, w http.ResponseWriter, r *http.Request)
+}{
+	{"/ping/id/", "GET", (*Node).handlePingID},
+	{"/store/", "POST", (*Node).handleStore},
+	{"/store/", "DELETE", (*Node).handleStore},
+	{"/store_here/", "POST", (*Node).handleStoreHere},
+	{"/name/store/", "POST", (*Node).handleStoreName},
+	{"/mutable/get/", "GET", (*Node).handleGetMutable},
+	{"/blob/", "GET", (*Node).handleFetchBlob},
+	{"/erasure/", "GET", (*Node).handleFetchErasure},
+	{"/iterative/findnode/", "GET", (*Node).handleIterativeFindNode},
+	{"/iterative/findvalue/", "GET", (*Node).handleIterativeFindValue},
+	{"/immutable/findvalue/", "GET", (*Node).handleFindImmutable},
+	{"/name/findvalue/", "GET", (*Node).handleFindName},
+	{"/routing/closest/", "GET", (*Node).handleRouting},
+	{"/v1/ping/id/", "GET", (*Node).handleV1PingID},
+	{"/v1/store/", "PUT", (*Node).handleV1Store},
+	{"/v1/store/", "DELETE", (*Node).handleV1Store},
+	{"/v1/store_here/", "PUT", (*Node).handleV1StoreHere},
+	{"/v1/name/", "GET", (*Node).handleV1Name},
+	{"/v1/name/", "PUT", (*Node).handleV1Name},
+	{"/v1/iterative/findnode/", "GET", (*Node).handleV1IterativeFindNode},
+	{"/v1/iterative/findvalue/", "GET", (*Node).handleV1IterativeFindValue},
+}
+
+// FuzzRESTPath sends requests with arbitrary paths, query strings and bodies to
+// the endpoints that parse their path. No request may crash the node or get a 500.
+func FuzzRESTPath(f *testing.F) {
+	key := ContentKey([]byte("value"))
+	for route := range restRoutes {
+		f.Add(uint8(route), key, "", []byte("value"))
+		f.Add(uint8(route), "", "", []byte(""))
+		f.Add(uint8(route), "not/a key", "", []byte(`{"value":"dmFsdWU=","ttl":"1h"}`))
+		f.Add(uint8(route), strings.Repeat("f", 41), "", []byte(`{"value":"dmFsdWU=","secret":"c2VjcmV0"}`))
+		f.Add(uint8(route), key, "r=4611686018427387904", []byte(""))
+		f.Add(uint8(route), key, "r=1000000000&trace=1", []byte(""))
+		f.Add(uint8(route), key, "quorum=-1&ttl=-5", []byte("value"))
+		f.Add(uint8(route), key, "ttl=9223372036854775807&secret=%zz", []byte("value"))
+	}
+
+	node := newTestNode(f, "10.0.0.1:4000")
+	f.Fuzz(func(t *testing.T, route uint8, suffix string, query string, body []byte) {
+		r := restRoutes[int(route)%len(restRoutes)]
+		req := httptest.NewRequest(r.method, "/", bytes.NewReader(body))
+		req.URL.Path = r.prefix + suffix
+		req.URL.RawQuery = query
+		w := httptest.NewRecorder()
+		r.handler(node, w, req)
+		if w.Code >= 500 && w.Code != http.StatusBadGateway && w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%s %s returned %d: %s", r.method, req.URL.Path, w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestRouting(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	contacts := make([]Contact, 20)
+	for i := range contacts {
+		contacts[i] = testContact(i)
+		node.rt.add(contacts[i])
+	}
+	node.rt.seen(contacts[0], 5*time.Millisecond)
+	get := func(path string, v interface{}) int {
+		w := httptest.NewRecorder()
+		node.handleRouting(w, httptest.NewRequest("GET", path, nil))
+		json.Unmarshal(w.Body.Bytes(), v)
+		return w.Code
+	}
+
+	var routing routingJSON
+	if code := get("/routing", &routing); code != http.StatusOK {
+		t.Fatalf("GET /routing returned %d", code)
+	}
+	held := 0
+	for _, bucket := range routing.Buckets {
+		first, last := node.bucketRange(bucket.Index)
+		if bucket.First != first.String() || bucket.Last != last.String() || bucket.PrefixLen != 160-bucket.Index {
+			t.Errorf("bucket %d has range %s-%s, prefix %d", bucket.Index, bucket.First, bucket.Last, bucket.PrefixLen)
+		}
+		held += len(bucket.Contacts) + len(bucket.Replacements)
+		for _, contact := range bucket.Contacts {
+			seen := contact.Address == contacts[0].Addr.String()
+			if (contact.LastSeen != nil) != seen || seen && contact.RTTMs != 5 {
+				t.Errorf("contact %s has last seen %v, RTT %gms", contact.Address, contact.LastSeen, contact.RTTMs)
+			}
+		}
+	}
+	if held != len(contacts) || routing.Contacts != len(node.rt.contacts()) {
+		t.Errorf("routing table lists %d of %d contacts, %d counted", held, len(contacts), routing.Contacts)
+	}
+
+	var reply struct{ Contacts []routingContactJSON }
+	target := testContact(100).Id
+	if code := get("/routing/closest/"+target.String(), &reply); code != http.StatusOK {
+		t.Fatalf("GET /routing/closest returned %d", code)
+	}
+	want := closest(node.rt.contacts(), target, k)
+	if len(reply.Contacts) != len(want) {
+		t.Fatalf("got %d closest contacts, want %d", len(reply.Contacts), len(want))
+	}
+	for i, contact := range reply.Contacts {
+		if contact.ID != want[i].Id.String() {
+			t.Errorf("closest contact %d is %s, want %s", i, contact.Address, want[i].Addr.String())
+		}
+	}
+	if code := get("/routing/closest/xyz", &reply); code != http.StatusBadRequest {
+		t.Errorf("GET of a bad ID returned %d", code)
+	}
+	if code := get("/routing/elsewhere", &reply); code != http.StatusNotFound {
+		t.Errorf("GET of an unknown path returned %d", code)
+	}
+}
@@ -0,0 +1,751 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// This file contains version 1 of the control API. Unlike the original
+// endpoints in rest.go, requests and responses are JSON and failures are
+// reported with an HTTP status code and an error object:
+//
+//	{"error": {"code": "not_found", "message": "key abcd not found"}}
+
+// apiErrorJSON is the body of every failed /v1/ response
+type apiErrorJSON struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// tracedErrorJSON is the body of a failed lookup that was traced
+type tracedErrorJSON struct {
+	apiErrorJSON
+	Trace *LookupTrace `json:"trace"`
+}
+
+// contactJSON is the /v1/ representation of a Contact
+type contactJSON struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+func contactsToJSON(contacts []Contact) []contactJSON {
+	result := make([]contactJSON, 0, len(contacts))
+	for _, contact := range contacts {
+		result = append(result, contactJSON{contact.Id.String(), contact.Addr.String()})
+	}
+	return result
+}
+
+// storeRequestJSON is the body of a /v1/ store request
+type storeRequestJSON struct {
+	Value []byte `json:"value"`
+	// TTL is a duration such as "10m" or a number of seconds
+	TTL    string `json:"ttl,omitempty"`
+	Secret string `json:"secret,omitempty"`
+	Quorum int    `json:"quorum,omitempty"`
+}
+
+// deleteRequestJSON is the body of a /v1/ delete request, either the secret
+// the value was stored with or a signed empty mutable value
+type deleteRequestJSON struct {
+	Secret    string `json:"secret,omitempty"`
+	PublicKey []byte `json:"public_key,omitempty"`
+	Salt      []byte `json:"salt,omitempty"`
+	Seq       int64  `json:"seq,omitempty"`
+	Sig       []byte `json:"sig,omitempty"`
+}
+
+// tableEntryJSON is one key in the /v1/table response
+type tableEntryJSON struct {
+	Key       string `json:"key"`
+	Name      string `json:"name,omitempty"`
+	Value     []byte `json:"value"`
+	IsOrigin  bool   `json:"is_origin"`
+	Deleted   bool   `json:"deleted"`
+	Cached    bool   `json:"cached"`
+	Immutable bool   `json:"immutable"`
+	Seq       *int64 `json:"seq,omitempty"`
+	TTL       int64  `json:"ttl"`
+	Publisher string `json:"publisher,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, format string, args ...interface{}) {
+	var body apiErrorJSON
+	body.Error.Code = code
+	body.Error.Message = fmt.Sprintf(format, args...)
+	writeJSON(w, status, body)
+}
+
+// requireMethod responds with 405 and returns false if r doesn't use one of
+// methods
+func requireMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if method == r.Method {
+			return true
+		}
+	}
+	for _, method := range methods {
+		w.Header().Add("Allow", method)
+	}
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "%s is not allowed, use %v", r.Method, methods)
+	return false
+}
+
+// parseID strictly parses a hex node ID or key, responding with 400 if it's
+// malformed
+func parseID(w http.ResponseWriter, idString string) (NodeID, bool) {
+	id, err := ParseKey(idString)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_id", "%s", err)
+		return id, false
+	}
+	return id, true
+}
+
+// parseKey is parseID for keys, which are returned the way the node stores
+// them, as 40 lower case hex digits
+func parseKey(w http.ResponseWriter, key string) (string, bool) {
+	id, ok := parseID(w, key)
+	return id.String(), ok
+}
+
+// decodeBody decodes the JSON request body into v, responding with 400 or 413
+// if it can't
+func (node *Node) decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if node.config.MaxValueSize > 0 {
+		// values are base64 encoded
+		r.Body = http.MaxBytesReader(w, r.Body, int64(node.config.MaxValueSize)*2+4096)
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "too_large", "request body is too large")
+			return false
+		}
+		writeError(w, http.StatusBadRequest, "bad_request", "malformed request body: %s", err)
+		return false
+	}
+	return true
+}
+
+// runLookup runs lookup, responding with 504 and returning false if it doesn't
+// finish within lookupTimeout
+func runLookup(w http.ResponseWriter, lookup func()) bool {
+	done := make(chan bool, 1)
+	go func() {
+		lookup()
+		done <- true
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(lookupTimeout):
+		writeError(w, http.StatusGatewayTimeout, "timeout", "lookup did not finish within %s", lookupTimeout)
+		return false
+	}
+}
+
+func (node *Node) handleV1PingIP(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "GET") {
+		return
+	}
+
+	ipString := r.URL.Path[len("/v1/ping/ip/"):]
+	addr, err := net.ResolveTCPAddr("", ipString)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_address", "couldn't resolve address %s: %s", ipString, err)
+		return
+	}
+
+	if !node.doPing(*addr) {
+		writeError(w, http.StatusBadGateway, "unreachable", "PING of %s failed", addr)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"address": addr.String()})
+}
+
+func (node *Node) handleV1PingID(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "GET") {
+		return
+	}
+
+	id, ok := parseID(w, r.URL.Path[len("/v1/ping/id/"):])
+	if !ok {
+		return
+	}
+	contact := node.rt.ContactFromID(id)
+	if contact == nil {
+		writeError(w, http.StatusNotFound, "not_found", "%s is not in the routing table", id)
+		return
+	}
+
+	if !node.doPing(contact.Addr) {
+		writeError(w, http.StatusBadGateway, "unreachable", "PING of %s failed", contact.Addr.String())
+		return
+	}
+	writeJSON(w, http.StatusOK, contactJSON{contact.Id.String(), contact.Addr.String()})
+}
+
+func (node *Node) handleV1Store(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "PUT", "POST", "DELETE") {
+		return
+	}
+	key, ok := parseKey(w, r.URL.Path[len("/v1/store/"):])
+	if !ok {
+		return
+	}
+	if r.Method == "DELETE" {
+		node.handleV1Delete(w, r, key)
+		return
+	}
+
+	node.v1StoreFromRequest(w, r, key, "")
+}
+
+// v1StoreFromRequest publishes the value in the body of r under key, which was
+// derived from name if it isn't empty
+func (node *Node) v1StoreFromRequest(w http.ResponseWriter, r *http.Request, key string, name string) {
+	var req storeRequestJSON
+	if !node.decodeBody(w, r, &req) {
+		return
+	}
+	ttl, err := parseTTL(req.TTL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_ttl", "%s", err)
+		return
+	}
+	if req.Quorum < 0 || req.Quorum > k {
+		writeError(w, http.StatusBadRequest, "bad_quorum", "quorum must be between 1 and %d", k)
+		return
+	}
+	if req.Quorum == 0 {
+		req.Quorum = 1
+	}
+	if node.config.MaxValueSize > 0 && len(req.Value) > node.config.MaxValueSize {
+		writeError(w, http.StatusRequestEntityTooLarge, "too_large", "value is larger than the %d byte limit", node.config.MaxValueSize)
+		return
+	}
+	node.logs.rest.Debugf("Received v1 STORE for key: (%s)", key)
+
+	args := StoreArgs{
+		Source:    node.addr,
+		Key:       key,
+		Val:       req.Value,
+		Published: time.Now(),
+		TTL:       ttl,
+		Publisher: requestPublisher(r),
+		Name:      name,
+	}
+	if req.Secret != "" {
+		args.OwnerHash = OwnerHash([]byte(req.Secret))
+	}
+
+	var acks int
+	if !runLookup(w, func() { acks, err = node.publishQuorum(args, req.Quorum) }) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "quorum_failed", "%s", err)
+		return
+	}
+	result := map[string]interface{}{"key": key, "replicas": acks}
+	if name != "" {
+		result["name"] = name
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (node *Node) handleV1Name(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "GET", "PUT", "POST") {
+		return
+	}
+	name := r.URL.Path[len("/v1/name/"):]
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "bad_name", "name is empty")
+		return
+	}
+	key := NameKey(name)
+	if r.Method != "GET" {
+		node.v1StoreFromRequest(w, r, key, name)
+		return
+	}
+
+	var value []byte
+	if !runLookup(w, func() { value = node.FindName(name) }) {
+		return
+	}
+	if value == nil {
+		writeError(w, http.StatusNotFound, "not_found", "name %q not found", name)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"name": name, "key": key, "value": value})
+}
+
+func (node *Node) handleV1Delete(w http.ResponseWriter, r *http.Request, key string) {
+	var req deleteRequestJSON
+	if !node.decodeBody(w, r, &req) {
+		return
+	}
+
+	args := DeleteArgs{Source: node.addr, Key: key, Deleted: time.Now()}
+	if req.Secret != "" {
+		args.Secret = []byte(req.Secret)
+	} else if req.Sig != nil {
+		args.Mutable = &MutableRecord{PublicKey: req.PublicKey, Salt: req.Salt, Seq: req.Seq, Sig: req.Sig}
+	} else {
+		writeError(w, http.StatusBadRequest, "bad_request", "delete needs a secret or a signed record")
+		return
+	}
+	node.logs.rest.Debugf("Received v1 DELETE for key: (%s)", key)
+
+	var acks int
+	if !runLookup(w, func() { acks = node.doIterativeDelete(args) }) {
+		return
+	}
+	if acks == 0 {
+		writeError(w, http.StatusForbidden, "delete_refused", "no node accepted the delete of %s", key)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "replicas": acks})
+}
+
+func (node *Node) handleV1StoreHere(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "PUT", "POST") {
+		return
+	}
+	key, ok := parseKey(w, r.URL.Path[len("/v1/store_here/"):])
+	if !ok {
+		return
+	}
+
+	var req storeRequestJSON
+	if !node.decodeBody(w, r, &req) {
+		return
+	}
+	ttl, err := parseTTL(req.TTL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_ttl", "%s", err)
+		return
+	}
+	node.logs.rest.Debugf("Received v1 STORE_HERE for key: (%s)", key)
+
+	now := time.Now()
+	kv := &KV{
+		key:       key,
+		val:       req.Value,
+		isOrigin:  true,
+		published: now,
+		expires:   now.Add(node.config.capTTL(ttl)),
+		publisher: requestPublisher(r),
+	}
+	if req.Secret != "" {
+		kv.ownerHash = OwnerHash([]byte(req.Secret))
+	}
+	err = node.ht.update(key, func(existing *KV) (*KV, error) {
+		return kv, node.ht.checkLimits(&node.config, existing, kv)
+	})
+	if err != nil {
+		writeError(w, http.StatusInsufficientStorage, "store_refused", "%s", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "replicas": 1})
+}
+
+func (node *Node) handleV1IterativeFindNode(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "GET") {
+		return
+	}
+	id, ok := parseKey(w, r.URL.Path[len("/v1/iterative/findnode/"):])
+	if !ok {
+		return
+	}
+
+	var contacts []Contact
+	var stats LookupStats
+	if traceRequested(r) {
+		stats.Trace = new(LookupTrace)
+	}
+	if !runLookup(w, func() { contacts = node.doIterativeFindNodeStats(id, &stats) }) {
+		return
+	}
+	result := map[string]interface{}{"contacts": contactsToJSON(contacts)}
+	if stats.Trace != nil {
+		result["trace"] = stats.Trace
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (node *Node) handleV1IterativeFindValue(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "GET") {
+		return
+	}
+	key, ok := parseKey(w, r.URL.Path[len("/v1/iterative/findvalue/"):])
+	if !ok {
+		return
+	}
+	quorum := 1
+	if quorumString := r.URL.Query().Get("r"); quorumString != "" {
+		if _, err := fmt.Sscan(quorumString, &quorum); err != nil || quorum < 1 || quorum > k {
+			writeError(w, http.StatusBadRequest, "bad_quorum", "r must be between 1 and %d", k)
+			return
+		}
+	}
+
+	var value []byte
+	var stats LookupStats
+	if traceRequested(r) {
+		if quorum > 1 {
+			writeError(w, http.StatusBadRequest, "bad_trace", "quorum reads can't be traced")
+			return
+		}
+		stats.Trace = new(LookupTrace)
+	}
+	lookup := func() {
+		if quorum > 1 {
+			value = node.doQuorumFindValue(key, quorum)
+		} else {
+			value = node.doIterativeFindValueStats(key, false, &stats)
+		}
+	}
+	if !runLookup(w, lookup) {
+		return
+	}
+	if value == nil && stats.Trace != nil {
+		var body tracedErrorJSON
+		body.Error.Code = "not_found"
+		body.Error.Message = fmt.Sprintf("key %s not found", key)
+		body.Trace = stats.Trace
+		writeJSON(w, http.StatusNotFound, body)
+		return
+	}
+	if value == nil {
+		writeError(w, http.StatusNotFound, "not_found", "key %s not found", key)
+		return
+	}
+	result := map[string]interface{}{"key": key, "value": value}
+	if quorum == 1 {
+		result["hops"] = stats.Rounds
+		result["rpcs"] = stats.RPCs
+		result["local"] = stats.Local
+		result["cached"] = stats.Cached
+	}
+	if stats.Trace != nil {
+		result["trace"] = stats.Trace
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (node *Node) handleV1OneshotFindNode(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "GET") {
+		return
+	}
+	id, ok := parseKey(w, r.URL.Path[len("/v1/oneshot/findnode/"):])
+	if !ok {
+		return
+	}
+	addr, err := net.ResolveTCPAddr("", r.URL.Query().Get("node"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_address", "node=<ip:port> is required: %s", err)
+		return
+	}
+
+	var reply FindNodeReply
+	if !node.doRPC("FindNode", *addr, FindNodeArgs{node.addr, id}, &reply) {
+		writeError(w, http.StatusBadGateway, "unreachable", "FINDNODE RPC to %s failed", addr)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"contacts": contactsToJSON(reply.Contacts)})
+}
+
+func (node *Node) handleV1OneshotFindValue(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "GET") {
+		return
+	}
+	key, ok := parseKey(w, r.URL.Path[len("/v1/oneshot/findvalue/"):])
+	if !ok {
+		return
+	}
+	addr, err := net.ResolveTCPAddr("", r.URL.Query().Get("node"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_address", "node=<ip:port> is required: %s", err)
+		return
+	}
+
+	var reply FindValueReply
+	if !node.doRPC("FindValue", *addr, FindValueArgs{node.addr, key}, &reply) {
+		writeError(w, http.StatusBadGateway, "unreachable", "FINDVALUE RPC to %s failed", addr)
+		return
+	}
+	result := map[string]interface{}{
+		"value":    reply.Val,
+		"contacts": contactsToJSON(reply.Contacts),
+		"deleted":  reply.Deleted,
+	}
+	if reply.Mutable != nil {
+		result["seq"] = reply.Mutable.Seq
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (node *Node) handleV1Table(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "GET") {
+		return
+	}
+
+	entries := make([]tableEntryJSON, 0)
+	for kv := range node.ht.Iterator() {
+		entry := tableEntryJSON{
+			Key:       kv.key,
+			Name:      kv.name,
+			Value:     kv.val,
+			IsOrigin:  kv.isOrigin,
+			Deleted:   kv.tombstone,
+			Cached:    kv.cached,
+			Immutable: kv.immutable,
+			Publisher: kv.publisher,
+		}
+		if kv.mutable != nil {
+			seq := kv.mutable.Seq
+			entry.Seq = &seq
+		}
+		if !kv.tombstone {
+			entry.TTL = int64(kv.ttl().Seconds())
+		}
+		entries = append(entries, entry)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entries": entries})
+}
+
+func (node *Node) handleV1Routing(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "GET") {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, node.routingJSON())
+}
+
+// routingJSON describes each non-empty bucket of the routing table
+func (node *Node) routingJSON() routingJSON {
+	routing := routingJSON{ID: node.id.String(), Address: node.addr.String(), Buckets: make([]bucketJSON, 0)}
+	for index := range node.rt.kBuckets {
+		contacts, replacements := node.rt.bucketEntries(index)
+		if len(contacts) == 0 && len(replacements) == 0 {
+			continue
+		}
+		first, last := node.bucketRange(index)
+		routing.Buckets = append(routing.Buckets, bucketJSON{
+			Index:        index,
+			PrefixLen:    len(node.id)*8 - index,
+			First:        first.String(),
+			Last:         last.String(),
+			Contacts:     routingEntriesToJSON(contacts),
+			Replacements: routingEntriesToJSON(replacements),
+		})
+		routing.Contacts += len(contacts)
+	}
+	return routing
+}
+
+func (node *Node) handleV1Stats(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "GET") {
+		return
+	}
+
+	stats := statsJSON{ID: node.id.String(), Address: node.addr.String()}
+	stats.Keys, stats.Bytes = node.ht.size()
+	for kv := range node.ht.Iterator() {
+		if kv.tombstone {
+			stats.Tombstones++
+		} else if kv.cached {
+			stats.Cached++
+		} else if kv.isOrigin {
+			stats.Origin++
+		}
+	}
+	for _, bucket := range node.rt.kBuckets {
+		if n := len(bucket.getAllContacts()); n > 0 {
+			stats.Contacts += n
+			stats.Buckets++
+		}
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (node *Node) handleV1Shutdown(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, "POST") {
+		return
+	}
+
+	node.logs.rest.Infof("Shutdown received. Terminating")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "shutting down"})
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.Exit(0)
+	}()
+}
+
+// routingJSON is the /v1/routing and /routing response
+type routingJSON struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	// Contacts is the number of contacts in Buckets, not counting
+	// replacements
+	Contacts int          `json:"contacts"`
+	Buckets  []bucketJSON `json:"buckets"`
+}
+
+// bucketJSON is one non-empty k-bucket in the /v1/routing response. It holds
+// the IDs from First to Last, which share the node's ID in their first
+// PrefixLen-1 bits and differ from it in the next.
+type bucketJSON struct {
+	Index        int                  `json:"index"`
+	PrefixLen    int                  `json:"prefix_len"`
+	First        string               `json:"first"`
+	Last         string               `json:"last"`
+	Contacts     []routingContactJSON `json:"contacts"`
+	Replacements []routingContactJSON `json:"replacements"`
+}
+
+// routingContactJSON is a contact in the routing table and how it has been
+// answering
+type routingContactJSON struct {
+	ID       string     `json:"id"`
+	Address  string     `json:"address"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	// Failures is the number of RPCs in a row it hasn't answered
+	Failures int `json:"failures"`
+	// RTTMs is the smoothed round trip time of the RPCs it has answered
+	RTTMs float64 `json:"rtt_ms,omitempty"`
+}
+
+func routingEntriesToJSON(entries []routingEntry) []routingContactJSON {
+	result := make([]routingContactJSON, 0, len(entries))
+	for _, entry := range entries {
+		contact := routingContactJSON{
+			ID:       entry.Id.String(),
+			Address:  entry.Addr.String(),
+			Failures: entry.Failures,
+			RTTMs:    milliseconds(entry.RTT),
+		}
+		if !entry.LastSeen.IsZero() {
+			lastSeen := entry.LastSeen
+			contact.LastSeen = &lastSeen
+		}
+		result = append(result, contact)
+	}
+	return result
+}
+
+// statsJSON is the /v1/stats response
+type statsJSON struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	// Keys includes tombstones, cached copies and keys we originated
+	Keys       int   `json:"keys"`
+	Bytes      int64 `json:"bytes"`
+	Tombstones int   `json:"tombstones"`
+	Cached     int   `json:"cached"`
+	Origin     int   `json:"origin"`
+	// Contacts is the size of the routing table, spread over Buckets
+	// non-empty buckets
+	Contacts int `json:"contacts"`
+	Buckets  int `json:"buckets"`
+}
+
+// setupV1Endpoints registers handlers for version 1 of the control API
+func (node *Node) setupV1Endpoints() {
+	// Anything else under /v1/ is a 404 with an error object
+	http.HandleFunc("/v1/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no_endpoint", "no endpoint %s", r.URL.Path)
+	})
+
+	// GET /v1/ping/ip/<ip addr>
+	http.HandleFunc("/v1/ping/ip/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1PingIP(w, r)
+	})
+
+	// GET /v1/ping/id/<id>
+	http.HandleFunc("/v1/ping/id/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1PingID(w, r)
+	})
+
+	// PUT /v1/store/<key>
+	// Body is {"value": <base64>, "ttl": <duration>, "secret": <secret>, "quorum": <acks>}
+	//
+	// DELETE /v1/store/<key>
+	// Body is {"secret": <secret>} or a signed empty mutable value
+	http.HandleFunc("/v1/store/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1Store(w, r)
+	})
+
+	// PUT /v1/store_here/<key>
+	// Body is as for /v1/store/
+	http.HandleFunc("/v1/store_here/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1StoreHere(w, r)
+	})
+
+	// PUT /v1/name/<name>
+	// Body is as for /v1/store/, the key is the hash of name (see NameKey)
+	//
+	// GET /v1/name/<name>
+	http.HandleFunc("/v1/name/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1Name(w, r)
+	})
+
+	// GET /v1/iterative/findnode/<id>
+	http.HandleFunc("/v1/iterative/findnode/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1IterativeFindNode(w, r)
+	})
+
+	// GET /v1/iterative/findvalue/<key>[?r=<replies>]
+	http.HandleFunc("/v1/iterative/findvalue/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1IterativeFindValue(w, r)
+	})
+
+	// GET /v1/oneshot/findnode/<id>?node=<ip:port>
+	http.HandleFunc("/v1/oneshot/findnode/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1OneshotFindNode(w, r)
+	})
+
+	// GET /v1/oneshot/findvalue/<key>?node=<ip:port>
+	http.HandleFunc("/v1/oneshot/findvalue/", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1OneshotFindValue(w, r)
+	})
+
+	// GET /v1/table
+	http.HandleFunc("/v1/table", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1Table(w, r)
+	})
+
+	// GET /v1/routing
+	http.HandleFunc("/v1/routing", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1Routing(w, r)
+	})
+
+	// GET /v1/stats
+	http.HandleFunc("/v1/stats", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1Stats(w, r)
+	})
+
+	// POST /v1/shutdown
+	http.HandleFunc("/v1/shutdown", func(w http.ResponseWriter, r *http.Request) {
+		node.handleV1Shutdown(w, r)
+	})
+}
@@ -1,56 +1,171 @@
 package kademlia
 
 import (
-	"math/big"
 	"sort"
 	"sync"
+	"time"
 )
 
 // This file contains the iterative RPCs used for information progagation throughout nodes
-// Calls STORE RPC on k Contacts ( Don't call on self?)
-func (node *Node) doIterativeStore(key string, value []byte) {
-	shortlist := node.doIterativeFindNode(key)
+// Calls STORE RPC on the k closest Contacts, storing locally instead of
+// sending an RPC if we are one of them
+// Returns the number of nodes that accepted the value and the last rejection
+func (node *Node) doIterativeStore(args StoreArgs) (int, error) {
+	toFindID := keyID(args.Key)
+
+	shortlist := append(node.doIterativeFindNode(args.Key), *NewContact(node.addr))
+	shortlist = RemoveDupesFromShortlist(shortlist)
+	if node.isLeaving() {
+		// hand the value on to the k closest other nodes. The lookup may
+		// have counted us among the k closest, so make up the numbers from
+		// our own routing table.
+		shortlist = append(shortlist, node.rt.findKNearestContacts(toFindID)...)
+		shortlist = RemoveDupesFromShortlist(shortlist)
+		others := shortlist[:0]
+		for _, contact := range shortlist {
+			if contact.Addr.String() != node.addr.String() {
+				others = append(others, contact)
+			}
+		}
+		shortlist = others
+	}
+	sort.Slice(shortlist, func(i, j int) bool {
+		return closerTo(toFindID, shortlist[i].Id, shortlist[j].Id)
+	})
+	candidates := shortlist
+	if len(shortlist) > k {
+		shortlist = shortlist[:k]
+	}
 
-	// get k contacts and send STORE RPC to each
-	for _, contact := range shortlist {
-		go func(contact Contact) {
-			args := StoreArgs{node.addr, key, value}
-			var reply StoreReply
-			if !node.doRPC("Store", contact.Addr, args, &reply) {
-				return
+	acks, lastErr := node.storeOn(args, shortlist)
+	if node.isLeaving() {
+		// make up for any that didn't take the value with the next closest
+		for next := len(shortlist); acks < k && next < len(candidates); next++ {
+			if err := node.sendStore(args, candidates[next].Addr); err != nil {
+				lastErr = err
+			} else {
+				acks++
 			}
-		}(contact)
+		}
+	}
+	node.logs.store.Info("Stored value", "key", args.Key, "acks", acks, "nodes", len(shortlist))
+	return acks, lastErr
+}
+
+// storeOn sends a STORE RPC to each of contacts at once, storing locally if we
+// are one of them, and returns how many accepted and the last rejection
+func (node *Node) storeOn(args StoreArgs, contacts []Contact) (int, error) {
+	errChan := make(chan error, len(contacts))
+	node.transport.Parallel(len(contacts), func(i int) {
+		contact := contacts[i]
+		if contact.Addr.String() == node.addr.String() {
+			errChan <- node.Store(args, &StoreReply{})
+			return
+		}
+		errChan <- node.sendStore(args, contact.Addr)
+	})
+
+	acks := 0
+	var lastErr error
+	for i := 0; i < len(contacts); i++ {
+		if err := <-errChan; err != nil {
+			lastErr = err
+		} else {
+			acks++
+		}
+	}
+	return acks, lastErr
+}
+
+// Calls DELETE RPC on the k closest Contacts and deletes locally
+// Returns the number of nodes that accepted the delete
+func (node *Node) doIterativeDelete(args DeleteArgs) int {
+	acks := 0
+	if node.Delete(args, &DeleteReply{}) == nil {
+		acks++
+	}
+
+	shortlist := make([]Contact, 0, k)
+	for _, contact := range node.doIterativeFindNode(args.Key) {
+		if contact.Addr.String() != node.addr.String() {
+			shortlist = append(shortlist, contact)
+		}
+	}
+	ackChan := make(chan bool, len(shortlist))
+	node.transport.Parallel(len(shortlist), func(i int) {
+		ackChan <- node.sendDelete(args, shortlist[i].Addr) == nil
+	})
+	for i := 0; i < len(shortlist); i++ {
+		if <-ackChan {
+			acks++
+		}
 	}
+	return acks
+}
+
+// LookupStats describes how an iterative lookup went
+type LookupStats struct {
+	// Rounds is the number of rounds of RPCs sent, the hop count of the lookup
+	Rounds int
+	// RPCs is the number of RPCs sent
+	RPCs int
+	// Local is set if we held the value ourselves
+	Local bool
+	// Cached is set if the value came from a cached copy
+	Cached bool
+	// Trace, if set, records each round of the lookup
+	Trace *LookupTrace
+}
+
+// Iteratively send a FINDVALUE RPC
+// If immutable is set, key is content-addressed and any value that doesn't hash
+// to key is discarded and the lookup continues
+func (node *Node) doIterativeFindValue(key string, immutable bool) []byte {
+	return node.doIterativeFindValueStats(key, immutable, new(LookupStats))
 }
 
-func (node *Node) doIterativeFindValue(key string) []byte {
+// doIterativeFindValueStats is doIterativeFindValue, recording how the lookup
+// went in stats
+func (node *Node) doIterativeFindValueStats(key string, immutable bool, stats *LookupStats) []byte {
+	if stats.Trace == nil && node.config.TraceLookups {
+		stats.Trace = new(LookupTrace)
+	}
+	stats.Trace.begin("findvalue", key)
+	start := time.Now()
+	defer func() {
+		node.metrics.lookup("findvalue", stats.Rounds, time.Since(start))
+		if stats.Cached {
+			node.metrics.cacheHit()
+		}
+		node.finishTrace(stats.Trace)
+	}()
+
 	value, found := node.ht.get(key)
-	if found {
+	if found && (!immutable || isContentKey(key, value)) {
+		stats.Local = true
+		stats.Trace.stop("local")
 		return value
 	}
 
 	//Iterations continue until no contacts returned that are closer or if all contacts in shortlist are active (k contacts have been queried)
-	toFindID := new(big.Int)
-	toFindID.SetString(key, keyBase)
+	toFindID := keyID(key)
 	contacted := make(map[string]bool)
 	shortlist := make([]Contact, 0, k)
 	
 	// caching purposes
 	cache_contact := NewContact(node.addr);
-	cache_distance := distanceBetween(cache_contact.Id, *toFindID)
+	cache_distance := distanceBetween(cache_contact.Id, toFindID)
 	mu := &sync.Mutex{}
 
 	// add yourself to contacted
 	contacted[node.addr.String()] = true
 
-	shortlist = node.rt.findKNearestContacts(*toFindID)
-	node.logger.Printf("Found %d contacts", len(shortlist))
+	shortlist = node.rt.findKNearestContacts(toFindID)
+	node.logs.routing.Debugf("Found %d contacts", len(shortlist))
 
-	contactChan := make(chan []Contact)
-	valueChan := make(chan []byte)
 	// while nearest contacts is not same, keep on iterating
 	for {
-		node.logger.Printf("Starting a new round of FindValues")
+		node.logs.routing.Debugf("Starting a new round of FindValues")
 		found := 0
 		changed := false
 		toSend := make([]Contact, 0, alpha)
@@ -64,6 +179,7 @@ func (node *Node) doIterativeFindValue(key string) []byte {
 				continue
 			}
 			toSend = append(toSend, shortlist[i])
+			contacted[toPing.String()] = true
 			found++
 			if found == alpha {
 				break
@@ -71,67 +187,96 @@ func (node *Node) doIterativeFindValue(key string) []byte {
 		}
 
 		// send alpha (or maybe fewer) RPCs
-		for i := 0; i < len(toSend); i++ {
-			//toPing := toSend[i].Addr
-			go func(toSendContact Contact) {
-				toPing := toSendContact.Addr
-				response := node.doFindValue(key, toPing)
-				if response == nil {
-					// Error with performing doFindValue, ignoring for now
-					// TODO: Handle error (?)
-				} else if response.Val != nil {
-					// in this case, we found the value
-					node.logger.Printf("Got value from node %s at %s", toSendContact.Id.Text(keyBase), toSendContact.Addr.String())
-					if (caching_on) {
-						go node.doCacheDirect(*cache_contact, key, response.Val)
-					}	
-					valueChan <- response.Val
+		round := -1
+		if len(toSend) > 0 {
+			stats.Rounds++
+			stats.RPCs += len(toSend)
+			round = stats.Trace.round(shortlist)
+		}
+		contactChan := make(chan []Contact, len(toSend))
+		// replies with the value or a deletion
+		valueChan := make(chan *FindValueReply, len(toSend))
+		node.transport.Parallel(len(toSend), func(i int) {
+			toSendContact := toSend[i]
+			toPing := toSendContact.Addr
+			sent := time.Now()
+			response := node.doFindValue(key, toPing)
+			if response == nil {
+				// Error with performing doFindValue, ignoring for now
+				// TODO: Handle error (?)
+				stats.Trace.query(round, toPing, sent, TraceQuery{Error: "no reply"})
+				contactChan <- nil
+				return
+			} else if response.Deleted {
+				// the key has been deleted, stop looking
+				node.logs.routing.Debugf("Key %s was deleted according to %s", key, toSendContact.Addr.String())
+				stats.Trace.query(round, toPing, sent, TraceQuery{Deleted: true})
+				valueChan <- response
+				return
+			} else if response.Val != nil {
+				if immutable && !isContentKey(key, response.Val) {
+					// bad copy, carry on with the rest of the shortlist
+					node.logs.routing.Warnf("Discarding value from %s: does not hash to key %s", toSendContact.Addr.String(), key)
+					stats.Trace.query(round, toPing, sent, TraceQuery{Error: "value does not hash to key"})
+					contactChan <- nil
 					return
 				}
+				// in this case, we found the value
+				node.logs.routing.Debugf("Got value from node %s at %s", toSendContact.Id.String(), toSendContact.Addr.String())
+				stats.Trace.query(round, toPing, sent, TraceQuery{Value: true})
+				if (caching_on && !node.config.DisableCaching) {
+					mu.Lock()
+					cacheAt := *cache_contact
+					mu.Unlock()
+					node.cacheDirect(cacheAt, node.cacheArgs(key, response, immutable))
+				}	
+				valueChan <- response
+				return
+			}
 
-				// we didn't find the value but got new contacts to search
-				// also save it if we want to cache on it
-				// check if it's closer to the destination
-				mu.Lock()
-				contacted_distance := distanceBetween(toSendContact.Id, *toFindID)
-				if (contacted_distance.Cmp(cache_distance) == -1) {
-					cache_contact = &toSendContact
-					cache_distance = contacted_distance
-				}
-				mu.Unlock()
+			// we didn't find the value but got new contacts to search
+			// also save it if we want to cache on it
+			// check if it's closer to the destination
+			mu.Lock()
+			contacted_distance := distanceBetween(toSendContact.Id, toFindID)
+			if (contacted_distance.Cmp(cache_distance) == -1) {
+				cache_contact = &toSendContact
+				cache_distance = contacted_distance
+			}
+			mu.Unlock()
 
-				responseShortlist := response.Contacts
-				contacted[toPing.String()] = true
-
-				// update the shortlist
-				sort.Slice(responseShortlist, func(i, j int) bool {
-					iDist := distanceBetween(*toFindID, responseShortlist[i].Id)
-					jDist := distanceBetween(*toFindID, responseShortlist[j].Id)
-					return (iDist.Cmp(jDist) == -1)
-				})
-				sliceIndex := k
-				if len(responseShortlist) < k {
-					sliceIndex = len(responseShortlist)
-				}
-				contactChan <- responseShortlist[:sliceIndex]
-			}(toSend[i])
-		}
+			responseShortlist := response.Contacts
+			stats.Trace.query(round, toPing, sent, TraceQuery{Contacts: addresses(responseShortlist)})
+
+			// update the shortlist
+			sort.Slice(responseShortlist, func(i, j int) bool {
+				return closerTo(toFindID, responseShortlist[i].Id, responseShortlist[j].Id)
+			})
+			sliceIndex := k
+			if len(responseShortlist) < k {
+				sliceIndex = len(responseShortlist)
+			}
+			contactChan <- responseShortlist[:sliceIndex]
+		})
 
 		updatedShortlist := make([]Contact, len(shortlist), k)
 		copy(updatedShortlist, shortlist)
-		node.logger.Printf("Shortlist length %d", len(updatedShortlist))
+		node.logs.routing.Debugf("Shortlist length %d", len(updatedShortlist))
 		closer := 0
-		node.logger.Printf("Going to read from channel")
+		node.logs.routing.Debugf("Going to read from channel")
 		for i := 0; i < len(toSend); i++ {
-			var s []Contact
-			select {
-			case val := <-valueChan:
-				return val
-			case s = <-contactChan:
+			reply, s := nextFindValueReply(valueChan, contactChan)
+			if reply != nil {
+				stats.Cached = reply.Cached
+				stats.Trace.stop(replyResult(reply))
+				return reply.Val
+			}
+			if len(s) == 0 {
+				continue
 			}
-			newClosestDist := distanceBetween(*toFindID, s[0].Id)
+			newClosestDist := distanceBetween(toFindID, s[0].Id)
 			if len(updatedShortlist) > 0 {
-				currClosestDist := distanceBetween(*toFindID, updatedShortlist[0].Id)
+				currClosestDist := distanceBetween(toFindID, updatedShortlist[0].Id)
 				// if newClosestDist < currClosestDist
 				if newClosestDist.Cmp(currClosestDist) == -1 {
 					closer++
@@ -140,12 +285,10 @@ func (node *Node) doIterativeFindValue(key string) []byte {
 
 			updatedShortlist = append(updatedShortlist, s...)
 			updatedShortlist = RemoveDupesFromShortlist(updatedShortlist)
-			node.logger.Printf("Update: list length: %d\n", len(updatedShortlist))
+			node.logs.routing.Debugf("Update: list length: %d", len(updatedShortlist))
 			// update the shortlist
 			sort.Slice(updatedShortlist, func(i, j int) bool {
-				iDist := distanceBetween(*toFindID, updatedShortlist[i].Id)
-				jDist := distanceBetween(*toFindID, updatedShortlist[j].Id)
-				return (iDist.Cmp(jDist) == -1)
+				return closerTo(toFindID, updatedShortlist[i].Id, updatedShortlist[j].Id)
 			})
 			sliceIndex := k
 			if len(updatedShortlist) < k {
@@ -154,7 +297,7 @@ func (node *Node) doIterativeFindValue(key string) []byte {
 			updatedShortlist = updatedShortlist[:sliceIndex]
 		}
 
-		node.logger.Printf("Finished reading from channel")
+		node.logs.routing.Debugf("Finished reading from channel")
 
 		// if we didn't find anything closer in last round, ping the rest of the
 		// shortlist that are unseen
@@ -168,17 +311,21 @@ func (node *Node) doIterativeFindValue(key string) []byte {
 					sendingTo = append(sendingTo, shortlist[i])
 				}
 			}
-			value, responseShortlist := node.findValueToK(toFindID, sendingTo, cache_contact, cache_distance)
-			if value != nil {
+			round := -1
+			if len(sendingTo) > 0 {
+				stats.Rounds++
+				stats.RPCs += len(sendingTo)
+				round = stats.Trace.round(shortlist)
+			}
+			value, responseShortlist, done := node.findValueToK(key, sendingTo, round, cache_contact, cache_distance, immutable, stats)
+			if done {
 				return value
 			}
 			updatedShortlist = append(updatedShortlist, responseShortlist...)
 			updatedShortlist = RemoveDupesFromShortlist(updatedShortlist)
 			// update the shortlist
 			sort.Slice(updatedShortlist, func(i, j int) bool {
-				iDist := distanceBetween(*toFindID, updatedShortlist[i].Id)
-				jDist := distanceBetween(*toFindID, updatedShortlist[j].Id)
-				return (iDist.Cmp(jDist) == -1)
+				return closerTo(toFindID, updatedShortlist[i].Id, updatedShortlist[j].Id)
 			})
 			sliceIndex := k
 			if len(updatedShortlist) < k {
@@ -187,11 +334,11 @@ func (node *Node) doIterativeFindValue(key string) []byte {
 			updatedShortlist = updatedShortlist[:sliceIndex]
 		}
 
-		node.logger.Printf("Checking if shortlist has changed")
+		node.logs.routing.Debugf("Checking if shortlist has changed")
 		// check if the shortlist has changed at all
 		// if not, we should terminate
 		// comparing shortlist and updatedShortlist
-		node.logger.Printf("New shortlist has length %d", len(updatedShortlist))
+		node.logs.routing.Debugf("New shortlist has length %d", len(updatedShortlist))
 		changed = false
 		loopIndex := len(updatedShortlist)
 		if len(shortlist) < loopIndex {
@@ -202,7 +349,7 @@ func (node *Node) doIterativeFindValue(key string) []byte {
 				changed = true
 			}
 		}
-		node.logger.Println("Shortlist changed this round", changed)
+		node.logs.routing.Debugf("Shortlist changed this round: %t", changed)
 		if !changed {
 			return nil
 		}
@@ -214,22 +361,40 @@ func (node *Node) doIterativeFindValue(key string) []byte {
 // Iteratively send a FINDNODE RPC
 // Returns a shortlist of k closest nodes
 func (node *Node) doIterativeFindNode(key string) []Contact {
+	return node.doIterativeFindNodeStats(key, new(LookupStats))
+}
+
+// doIterativeFindNodeStats is doIterativeFindNode, recording how the lookup
+// went in stats
+func (node *Node) doIterativeFindNodeStats(key string, stats *LookupStats) []Contact {
+	if stats.Trace == nil && node.config.TraceLookups {
+		stats.Trace = new(LookupTrace)
+	}
+	stats.Trace.begin("findnode", key)
+	start := time.Now()
+	defer func() {
+		node.metrics.lookup("findnode", stats.Rounds, time.Since(start))
+		node.finishTrace(stats.Trace)
+	}()
+
 	//Iterations continue until no contacts returned that are closer or if all contacts in shortlist are active (k contacts have been queried)
-	toFindID := new(big.Int)
-	toFindID.SetString(key, keyBase)
+	toFindID := keyID(key)
 	contacted := make(map[string]bool)
 	shortlist := make([]Contact, 0, k)
 
+	// nodes that didn't answer, which are dropped from the shortlist
+	failed := make(map[string]bool)
+	mu := &sync.Mutex{}
+
 	// add yourself to contacted
 	contacted[node.addr.String()] = true
 
-	shortlist = node.rt.findKNearestContacts(*toFindID)
-	node.logger.Printf("Found %d contacts", len(shortlist))
+	shortlist = node.rt.findKNearestContacts(toFindID)
+	node.logs.routing.Debugf("Found %d contacts", len(shortlist))
 
-	contactChan := make(chan []Contact)
 	// while nearest contacts is not same, keep on iterating
 	for {
-		node.logger.Printf("Starting a new round of FindNodes")
+		node.logs.routing.Debugf("Starting a new round of FindNodes")
 		found := 0
 		changed := false
 		toSend := make([]Contact, 0, alpha)
@@ -243,6 +408,7 @@ func (node *Node) doIterativeFindNode(key string) []Contact {
 				continue
 			}
 			toSend = append(toSend, shortlist[i])
+			contacted[toPing.String()] = true
 			found++
 			if found == alpha {
 				break
@@ -250,36 +416,51 @@ func (node *Node) doIterativeFindNode(key string) []Contact {
 		}
 
 		// send alpha (or maybe fewer) RPCs
-		for i := 0; i < len(toSend); i++ {
-			toPing := toSend[i].Addr
-			go func() {
-				responseShortlist := node.doFindNode(key, toPing)
-				contacted[toPing.String()] = true
-
-				// update the shortlist
-				sort.Slice(responseShortlist, func(i, j int) bool {
-					iDist := distanceBetween(*toFindID, responseShortlist[i].Id)
-					jDist := distanceBetween(*toFindID, responseShortlist[j].Id)
-					return (iDist.Cmp(jDist) == -1)
-				})
-				sliceIndex := k
-				if len(responseShortlist) < k {
-					sliceIndex = len(responseShortlist)
-				}
-				contactChan <- responseShortlist[:sliceIndex]
-			}()
+		round := -1
+		if len(toSend) > 0 {
+			stats.Rounds++
+			stats.RPCs += len(toSend)
+			round = stats.Trace.round(shortlist)
 		}
+		contactChan := make(chan []Contact, len(toSend))
+		node.transport.Parallel(len(toSend), func(i int) {
+			toPing := toSend[i].Addr
+			sent := time.Now()
+			responseShortlist := node.doFindNode(key, toPing)
+			if responseShortlist == nil {
+				stats.Trace.query(round, toPing, sent, TraceQuery{Error: "no reply"})
+				mu.Lock()
+				failed[toPing.String()] = true
+				mu.Unlock()
+			} else {
+				stats.Trace.query(round, toPing, sent, TraceQuery{Contacts: addresses(responseShortlist)})
+			}
+
+			// update the shortlist
+			sort.Slice(responseShortlist, func(i, j int) bool {
+				return closerTo(toFindID, responseShortlist[i].Id, responseShortlist[j].Id)
+			})
+			sliceIndex := k
+			if len(responseShortlist) < k {
+				sliceIndex = len(responseShortlist)
+			}
+			contactChan <- responseShortlist[:sliceIndex]
+		})
 
 		updatedShortlist := make([]Contact, len(shortlist), k)
 		copy(updatedShortlist, shortlist)
-		node.logger.Printf("Shortlist length %d", len(updatedShortlist))
+		node.logs.routing.Debugf("Shortlist length %d", len(updatedShortlist))
 		closer := 0
-		node.logger.Printf("Going to read from channel")
+		node.logs.routing.Debugf("Going to read from channel")
 		for i := 0; i < len(toSend); i++ {
 			s := <-contactChan
-			newClosestDist := distanceBetween(*toFindID, s[0].Id)
+			if len(s) == 0 {
+				// the RPC failed or the node knew nobody
+				continue
+			}
+			newClosestDist := distanceBetween(toFindID, s[0].Id)
 			if len(updatedShortlist) > 0 {
-				currClosestDist := distanceBetween(*toFindID, updatedShortlist[0].Id)
+				currClosestDist := distanceBetween(toFindID, updatedShortlist[0].Id)
 				// if newClosestDist < currClosestDist
 				if newClosestDist.Cmp(currClosestDist) == -1 {
 					closer++
@@ -290,9 +471,7 @@ func (node *Node) doIterativeFindNode(key string) []Contact {
 			updatedShortlist = RemoveDupesFromShortlist(updatedShortlist)
 			// update the shortlist
 			sort.Slice(updatedShortlist, func(i, j int) bool {
-				iDist := distanceBetween(*toFindID, updatedShortlist[i].Id)
-				jDist := distanceBetween(*toFindID, updatedShortlist[j].Id)
-				return (iDist.Cmp(jDist) == -1)
+				return closerTo(toFindID, updatedShortlist[i].Id, updatedShortlist[j].Id)
 			})
 
 			sliceIndex := k
@@ -303,7 +482,7 @@ func (node *Node) doIterativeFindNode(key string) []Contact {
 
 		}
 
-		node.logger.Printf("Finished reading from channel")
+		node.logs.routing.Debugf("Finished reading from channel")
 
 		// if we didn't find anything closer in last round, ping the rest of the
 		// shortlist that are unseen
@@ -317,14 +496,18 @@ func (node *Node) doIterativeFindNode(key string) []Contact {
 					sendingTo = append(sendingTo, shortlist[i])
 				}
 			}
-			responseShortlist := node.findNodeToK(toFindID, sendingTo)
+			round := -1
+			if len(sendingTo) > 0 {
+				stats.Rounds++
+				stats.RPCs += len(sendingTo)
+				round = stats.Trace.round(shortlist)
+			}
+			responseShortlist := node.findNodeToK(key, sendingTo, round, failed, stats)
 			updatedShortlist = append(updatedShortlist, responseShortlist...)
 			updatedShortlist = RemoveDupesFromShortlist(updatedShortlist)
 			// update the shortlist
 			sort.Slice(updatedShortlist, func(i, j int) bool {
-				iDist := distanceBetween(*toFindID, updatedShortlist[i].Id)
-				jDist := distanceBetween(*toFindID, updatedShortlist[j].Id)
-				return (iDist.Cmp(jDist) == -1)
+				return closerTo(toFindID, updatedShortlist[i].Id, updatedShortlist[j].Id)
 			})
 			sliceIndex := k
 			if len(updatedShortlist) < k {
@@ -333,11 +516,13 @@ func (node *Node) doIterativeFindNode(key string) []Contact {
 			updatedShortlist = updatedShortlist[:sliceIndex]
 		}
 
-		node.logger.Printf("Checking if shortlist has changed")
+		updatedShortlist = withoutFailed(updatedShortlist, failed)
+
+		node.logs.routing.Debugf("Checking if shortlist has changed")
 		// check if the shortlist has changed at all
 		// if not, we should terminate
 		// comparing shortlist and updatedShortlist
-		node.logger.Printf("New shortlist has length %d", len(updatedShortlist))
+		node.logs.routing.Debugf("New shortlist has length %d", len(updatedShortlist))
 		changed = false
 		loopIndex := len(updatedShortlist)
 		if len(shortlist) < loopIndex {
@@ -348,7 +533,7 @@ func (node *Node) doIterativeFindNode(key string) []Contact {
 				changed = true
 			}
 		}
-		node.logger.Println("Shortlist changed this round", changed)
+		node.logs.routing.Debugf("Shortlist changed this round: %t", changed)
 		if !changed {
 			return updatedShortlist
 		}
@@ -360,17 +545,28 @@ func (node *Node) doIterativeFindNode(key string) []Contact {
 	//return shortlist
 }
 
-func (node *Node) findNodeToK(toFindID *big.Int, toSend []Contact) []Contact {
-	contactChan := make(chan []Contact)
+// findNodeToK sends a FINDNODE RPC to each of toSend as round round of a
+// lookup, recording the ones that don't answer in failed
+func (node *Node) findNodeToK(key string, toSend []Contact, round int, failed map[string]bool, stats *LookupStats) []Contact {
+	toFindID := keyID(key)
+	contactChan := make(chan []Contact, len(toSend))
+	mu := &sync.Mutex{}
 
-	for i := 0; i < len(toSend); i++ {
+	node.transport.Parallel(len(toSend), func(i int) {
 		toPing := toSend[i].Addr
-		go func() {
-			responseShortlist := node.doFindNode(toFindID.Text(keyBase), toPing)
+		sent := time.Now()
+		responseShortlist := node.doFindNode(key, toPing)
+		if responseShortlist == nil {
+			stats.Trace.query(round, toPing, sent, TraceQuery{Error: "no reply"})
+			mu.Lock()
+			failed[toPing.String()] = true
+			mu.Unlock()
+		} else {
+			stats.Trace.query(round, toPing, sent, TraceQuery{Contacts: addresses(responseShortlist)})
+		}
 
-			contactChan <- responseShortlist
-		}()
-	}
+		contactChan <- responseShortlist
+	})
 
 	// Wait for all rpcs to return
 	updatedShortlist := make([]Contact, 0)
@@ -380,9 +576,7 @@ func (node *Node) findNodeToK(toFindID *big.Int, toSend []Contact) []Contact {
 		updatedShortlist = RemoveDupesFromShortlist(updatedShortlist)
 		// update the shortlist
 		sort.Slice(updatedShortlist, func(i, j int) bool {
-			iDist := distanceBetween(*toFindID, updatedShortlist[i].Id)
-			jDist := distanceBetween(*toFindID, updatedShortlist[j].Id)
-			return (iDist.Cmp(jDist) == -1)
+			return closerTo(toFindID, updatedShortlist[i].Id, updatedShortlist[j].Id)
 		})
 		sliceIndex := k
 		if len(updatedShortlist) < k {
@@ -394,56 +588,102 @@ func (node *Node) findNodeToK(toFindID *big.Int, toSend []Contact) []Contact {
 	return updatedShortlist
 }
 
-func (node *Node) findValueToK(toFindID *big.Int, toSend []Contact, cache_contact *Contact, cache_distance *big.Int) ([]byte, []Contact) {
-	mu := &sync.Mutex{}
-	contactChan := make(chan []Contact)
-	valueChan := make(chan []byte)
+// withoutFailed returns the contacts in shortlist that aren't in failed
+func withoutFailed(shortlist []Contact, failed map[string]bool) []Contact {
+	remaining := make([]Contact, 0, len(shortlist))
+	for _, contact := range shortlist {
+		if !failed[contact.Addr.String()] {
+			remaining = append(remaining, contact)
+		}
+	}
+	return remaining
+}
 
-	for i := 0; i < len(toSend); i++ {
-		//toPing := toSend[i].Addr
-		go func(toSendContact Contact) {
-			toPing := toSendContact.Addr
-			response := node.doFindValue(toFindID.Text(keyBase), toPing)
-			if response == nil {
-				// Error with performing doFindValue, ignoring for now
-				// TODO: Handle error (?)
-			} else if response.Val != nil {
-				node.logger.Printf("Got value from node %s at %s", toSendContact.Id.Text(keyBase), toSendContact.Addr.String())
-				if (caching_on) {
-					go node.doCacheDirect(*cache_contact, toFindID.Text(keyBase), response.Val)
-				}
-				valueChan <- response.Val
+// nextFindValueReply waits for the next FINDVALUE reply. A value or deletion
+// that has already arrived is taken ahead of any contacts waiting alongside it.
+func nextFindValueReply(valueChan chan *FindValueReply, contactChan chan []Contact) (*FindValueReply, []Contact) {
+	select {
+	case reply := <-valueChan:
+		return reply, nil
+	default:
+	}
+	select {
+	case reply := <-valueChan:
+		return reply, nil
+	case s := <-contactChan:
+		return nil, s
+	}
+}
+
+// findValueToK sends a FINDVALUE RPC to each of toSend. done is set if the
+// lookup is over, either because the value was found or the key was deleted
+func (node *Node) findValueToK(key string, toSend []Contact, round int, cache_contact *Contact, cache_distance NodeID, immutable bool, stats *LookupStats) (value []byte, contacts []Contact, done bool) {
+	toFindID := keyID(key)
+	mu := &sync.Mutex{}
+	contactChan := make(chan []Contact, len(toSend))
+	valueChan := make(chan *FindValueReply, len(toSend))
+
+	node.transport.Parallel(len(toSend), func(i int) {
+		toSendContact := toSend[i]
+		toPing := toSendContact.Addr
+		sent := time.Now()
+		response := node.doFindValue(key, toPing)
+		if response == nil {
+			// Error with performing doFindValue, ignoring for now
+			// TODO: Handle error (?)
+			stats.Trace.query(round, toPing, sent, TraceQuery{Error: "no reply"})
+			contactChan <- nil
+			return
+		} else if response.Deleted {
+			node.logs.routing.Debugf("Key %s was deleted according to %s", key, toSendContact.Addr.String())
+			stats.Trace.query(round, toPing, sent, TraceQuery{Deleted: true})
+			valueChan <- response
+			return
+		} else if response.Val != nil {
+			if immutable && !isContentKey(key, response.Val) {
+				node.logs.routing.Warnf("Discarding value from %s: does not hash to key %s", toSendContact.Addr.String(), key)
+				stats.Trace.query(round, toPing, sent, TraceQuery{Error: "value does not hash to key"})
+				contactChan <- nil
 				return
 			}
-			mu.Lock()
-			contacted_distance := distanceBetween(toSendContact.Id, *toFindID)
-			if (contacted_distance.Cmp(cache_distance) == -1) {
-				cache_contact = &toSendContact
-				cache_distance = contacted_distance
+			node.logs.routing.Debugf("Got value from node %s at %s", toSendContact.Id.String(), toSendContact.Addr.String())
+			stats.Trace.query(round, toPing, sent, TraceQuery{Value: true})
+			if (caching_on && !node.config.DisableCaching) {
+				mu.Lock()
+				cacheAt := *cache_contact
+				mu.Unlock()
+				node.cacheDirect(cacheAt, node.cacheArgs(key, response, immutable))
 			}
-			mu.Unlock()
+			valueChan <- response
+			return
+		}
+		mu.Lock()
+		contacted_distance := distanceBetween(toSendContact.Id, toFindID)
+		if (contacted_distance.Cmp(cache_distance) == -1) {
+			cache_contact = &toSendContact
+			cache_distance = contacted_distance
+		}
+		mu.Unlock()
 
-			responseShortlist := response.Contacts
-			contactChan <- responseShortlist
-		}(toSend[i])
-	}
+		responseShortlist := response.Contacts
+		stats.Trace.query(round, toPing, sent, TraceQuery{Contacts: addresses(responseShortlist)})
+		contactChan <- responseShortlist
+	})
 
 	// Wait for all rpcs to return
 	updatedShortlist := make([]Contact, 0)
 	for i := 0; i < len(toSend); i++ {
-		var s []Contact
-		select {
-		case val := <-valueChan:
-			return val, nil
-		case s = <-contactChan:
+		reply, s := nextFindValueReply(valueChan, contactChan)
+		if reply != nil {
+			stats.Cached = reply.Cached
+			stats.Trace.stop(replyResult(reply))
+			return reply.Val, nil, true
 		}
 		updatedShortlist = append(updatedShortlist, s...)
 		updatedShortlist = RemoveDupesFromShortlist(updatedShortlist)
 		// update the shortlist
 		sort.Slice(updatedShortlist, func(i, j int) bool {
-			iDist := distanceBetween(*toFindID, updatedShortlist[i].Id)
-			jDist := distanceBetween(*toFindID, updatedShortlist[j].Id)
-			return (iDist.Cmp(jDist) == -1)
+			return closerTo(toFindID, updatedShortlist[i].Id, updatedShortlist[j].Id)
 		})
 		sliceIndex := k
 		if len(updatedShortlist) < k {
@@ -452,14 +692,55 @@ func (node *Node) findValueToK(toFindID *big.Int, toSend []Contact, cache_contac
 		updatedShortlist = updatedShortlist[:sliceIndex]
 	}
 
-	return nil, updatedShortlist
+	return nil, updatedShortlist, false
+}
+
+// replyResult returns how a lookup ended that got reply
+func replyResult(reply *FindValueReply) string {
+	if reply.Deleted {
+		return "deleted"
+	}
+	return "found"
 }
 
-func (node *Node) doCacheDirect(contact Contact, key string, value []byte) {
-	node.logger.Printf("Caching on node %s", contact.Addr.String())
-	args := StoreArgs{node.addr, key, value}
-	var reply StoreReply
-	if !node.doRPC("Store", contact.Addr, args, &reply) {
+// finishTrace completes trace and keeps it in the ring buffer, if the lookup
+// was traced
+func (node *Node) finishTrace(trace *LookupTrace) {
+	if trace == nil {
 		return
 	}
+	trace.finish()
+	node.traces.add(trace)
+}
+
+// cacheDirect stores a cached copy on contact in the background
+func (node *Node) cacheDirect(contact Contact, args StoreArgs) {
+	node.transport.Go(func() {
+		node.doCacheDirect(contact, args)
+	})
+}
+
+func (node *Node) doCacheDirect(contact Contact, args StoreArgs) {
+	node.logs.routing.Debugf("Caching on node %s", contact.Addr.String())
+	err := node.sendStore(args, contact.Addr)
+	node.metrics.cacheStore(err == nil)
+}
+
+// cacheArgs returns the STORE arguments for caching the value in response
+// Publication time and ownership are carried over so the cached copy can't
+// outlive a delete
+func (node *Node) cacheArgs(key string, response *FindValueReply, immutable bool) StoreArgs {
+	return StoreArgs{
+		Source:    node.addr,
+		Key:       key,
+		Val:       response.Val,
+		Immutable: immutable,
+		Mutable:   response.Mutable,
+		Published: response.Published,
+		OwnerHash: response.OwnerHash,
+		TTL:       response.TTL,
+		Publisher: response.Publisher,
+		Name:      response.Name,
+		Cached:    true,
+	}
 }
@@ -2,35 +2,29 @@ package kademlia
 
 import (
 	"container/list"
-	"crypto/sha1"
 	//"fmt"
-	"math/big"
 	"net"
 	"sort"
 	"sync"
+	"time"
 )
 
 // Contact is an entry in the k-bucket
 type Contact struct {
-	Id   big.Int
+	Id   NodeID
 	Addr net.TCPAddr
 }
 
 // NewContact creates a new Contact struct based on addr by taking the hash
 func NewContact(addr net.TCPAddr) *Contact {
-	hash := sha1.Sum([]byte(addr.String()))
-
-	id := *big.NewInt(0)
-	id.SetBytes(hash[:])
-
-	nodeEntry := Contact{id, addr}
+	nodeEntry := Contact{NodeIDFromString(addr.String()), addr}
 	return &nodeEntry
 }
 
 // AreEqualContacts returns true if contact Id and Addr are equivalent
-// structs can be compared, but structs containing big.Int cannot
+// structs can be compared, but not ones containing a net.TCPAddr
 func AreEqualContacts(a *Contact, b *Contact) bool {
-	return (a.Id.Cmp(&b.Id) == 0)
+	return a.Id == b.Id
 }
 
 // extra struct because we will want to implement split bucket
@@ -41,7 +35,12 @@ type RoutingTable struct {
 }
 
 func NewRoutingTable(owner *Node) *RoutingTable {
+	// every bucket is made up front, as contacts are added to them from
+	// concurrent RPCs
 	kBuckets := make([]*KBucket, 160)
+	for i := range kBuckets {
+		kBuckets[i] = NewKBucket(k)
+	}
 	numNeighbors := 0
 	rt := RoutingTable{owner, kBuckets, numNeighbors}
 	return &rt
@@ -53,42 +52,31 @@ func (self *RoutingTable) splitBucket() {
 
 }
 
-func (self *RoutingTable) findKNearestContacts(id big.Int) []Contact {
+func (self *RoutingTable) findKNearestContacts(id NodeID) []Contact {
 	// If the entire RT has less than k contacts, then just return all the contacts
 
 	kNearest := make([]Contact, 0, k)
 	// To find the k closest contacts, we start looking from the bucket that the contact would be in
-	index := self.owner.GetKBucketFromID(&id)
+	index := self.owner.GetKBucketFromID(id)
 	if index < 0 {
 		index = 0
 	}
 
-	// for all of these, need to check that the kbucket exists
-	if self.kBuckets[index] != nil {
-		kNearest = append(kNearest, self.kBuckets[index].getAllContacts()...)
-	}
+	kNearest = append(kNearest, self.kBuckets[index].getAllContacts()...)
 
 	// If less than k contacts are in the bucket, then take the closest from the left
+	// Contacts in every bucket to the left are between 2^index and
+	// 2^(index+1) from id, and aren't ordered by bucket, so take them all
 	if len(kNearest) < k {
-		// 0th bucket never populated <--- this is wrong, 1 is between 2^0 and 2^1
 		for curr := index - 1; curr >= 0; curr-- {
-			currBucket := self.kBuckets[curr]
-			if currBucket != nil {
-				kNearest = append(kNearest, currBucket.getAllContacts()...)
-			}
-			if len(kNearest) >= k {
-				break
-			}
+			kNearest = append(kNearest, self.kBuckets[curr].getAllContacts()...)
 		}
 	}
 
 	// Then go to the right
 	if len(kNearest) < k {
 		for curr := index + 1; curr < 160; curr++ {
-			currBucket := self.kBuckets[curr]
-			if currBucket != nil {
-				kNearest = append(kNearest, currBucket.getAllContacts()...)
-			}
+			kNearest = append(kNearest, self.kBuckets[curr].getAllContacts()...)
 			if len(kNearest) >= k {
 				break
 			}
@@ -97,9 +85,7 @@ func (self *RoutingTable) findKNearestContacts(id big.Int) []Contact {
 
 	// Return in order of distance to contact
 	sort.Slice(kNearest, func(i, j int) bool {
-		aDist := distanceBetween(id, kNearest[i].Id)
-		bDist := distanceBetween(id, kNearest[j].Id)
-		return (aDist.Cmp(bDist) == -1)
+		return closerTo(id, kNearest[i].Id, kNearest[j].Id)
 	})
 
 	slice_index := k
@@ -108,24 +94,24 @@ func (self *RoutingTable) findKNearestContacts(id big.Int) []Contact {
 	}
 	kNearest = kNearest[:slice_index]
 
-	self.owner.logger.Printf("Found %d neighbors", len(kNearest))
+	self.owner.logs.routing.Debugf("Found %d neighbors", len(kNearest))
 	return kNearest
 }
 
 func (self *RoutingTable) add(contact Contact) {
 	// Don't add yourself to the routing table under any circumstances
 	self_contact := Contact{self.owner.id, self.owner.addr}
-	self.owner.logger.Printf("My node ID: %s, other ID: %s", self.owner.id.Text(keyBase), contact.Id.Text(keyBase))
+	self.owner.logs.routing.Debugf("My node ID: %s, other ID: %s", self.owner.id, contact.Id)
 	if AreEqualContacts(&self_contact, &contact) {
 		return
 	}
 
 	index := self.owner.GetKBucketFromAddr(contact.Addr)
-	if self.kBuckets[index] == nil {
-		self.owner.logger.Printf("Creating bucket %d", index)
-		self.kBuckets[index] = NewKBucket(k)
+	if index < 0 {
+		// a contact claiming our address under another ID
+		return
 	}
-	self.owner.logger.Printf("Trying to put node %s in bucket %d", contact.Addr.String(), index)
+	self.owner.logs.routing.Debugf("Trying to put node %s in bucket %d", contact.Addr.String(), index)
 
 	self.kBuckets[index].addContact(contact)
 	//TODO: handle failure to add
@@ -133,9 +119,81 @@ func (self *RoutingTable) add(contact Contact) {
 
 func (self *RoutingTable) remove(contact Contact) {
 	index := self.owner.GetKBucketFromAddr(contact.Addr)
+	if index < 0 {
+		return
+	}
 	self.kBuckets[index].removeContact(contact)
 }
 
+// seen records that we heard from contact, adding it to the routing table and
+// clearing its failures. rtt is how long it took to answer an RPC we sent, or
+// 0 if it contacted us.
+func (self *RoutingTable) seen(contact Contact, rtt time.Duration) {
+	self.add(contact)
+	index := self.owner.GetKBucketFromAddr(contact.Addr)
+	if index >= 0 {
+		self.kBuckets[index].seenContact(contact, rtt)
+	}
+}
+
+// failed records that contact didn't answer an RPC. It is removed once there
+// is a replacement for it, or after staleLimit failures in a row.
+func (self *RoutingTable) failed(contact Contact) {
+	index := self.owner.GetKBucketFromAddr(contact.Addr)
+	if index < 0 {
+		return
+	}
+	if self.kBuckets[index].failContact(contact) {
+		self.owner.logs.routing.Infof("Removed stale node %s from bucket %d", contact.Addr.String(), index)
+	}
+}
+
+// contacts returns every contact in the routing table
+func (self *RoutingTable) contacts() []Contact {
+	contacts := make([]Contact, 0)
+	for _, bucket := range self.kBuckets {
+		contacts = append(contacts, bucket.getAllContacts()...)
+	}
+	return contacts
+}
+
+// seenContacts returns the number of contacts in the routing table that we
+// have heard from
+func (self *RoutingTable) seenContacts() int {
+	count := 0
+	for _, bucket := range self.kBuckets {
+		bucket.mu.Lock()
+		for e := bucket.contacts.Front(); e != nil; e = e.Next() {
+			contact := e.Value.(Contact)
+			if health := bucket.health[contact.Addr.String()]; health != nil && !health.lastSeen.IsZero() {
+				count++
+			}
+		}
+		bucket.mu.Unlock()
+	}
+	return count
+}
+
+// bucketEntries describes the contacts and replacement cache of bucket index
+func (self *RoutingTable) bucketEntries(index int) ([]routingEntry, []routingEntry) {
+	bucket := self.kBuckets[index]
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	return bucket.entries(bucket.contacts), bucket.entries(bucket.lruCache)
+}
+
+// entry describes contact, which needn't be in the routing table
+func (self *RoutingTable) entry(contact Contact) routingEntry {
+	index := self.owner.GetKBucketFromAddr(contact.Addr)
+	if index < 0 {
+		return routingEntry{Contact: contact}
+	}
+	bucket := self.kBuckets[index]
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	return bucket.entry(contact)
+}
+
 // Not even sure if we will use this
 func (self *RoutingTable) clear() {
 	// Note that this sets slice capacity to 0
@@ -145,15 +203,37 @@ func (self *RoutingTable) clear() {
 type KBucket struct {
 	contacts *list.List
 	k        int        // max number of contacts
-	lruCache *list.List // not implemented yet but explained in section 4.1
+	lruCache *list.List // replacement cache, explained in section 4.1
 	mu       *sync.Mutex
+	// health is how the contacts in the bucket and the replacement cache
+	// have been answering, by address
+	health map[string]*contactHealth
+}
+
+// contactHealth is how a contact has been answering RPCs
+type contactHealth struct {
+	// failures counts the RPCs in a row it hasn't answered
+	failures int
+	// lastSeen is when we last heard from it, zero if we only know of it
+	// from other nodes
+	lastSeen time.Time
+	// rtt is the smoothed round trip time of the RPCs it has answered
+	rtt time.Duration
+}
+
+// routingEntry describes a contact in a bucket
+type routingEntry struct {
+	Contact
+	Failures int
+	LastSeen time.Time
+	RTT      time.Duration
 }
 
 func NewKBucket(k int) *KBucket {
 	contacts := list.New()
 	lruCache := list.New()
 	mu := &sync.Mutex{}
-	kBucket := KBucket{contacts, k, lruCache, mu}
+	kBucket := KBucket{contacts, k, lruCache, mu, make(map[string]*contactHealth)}
 	return &kBucket
 }
 
@@ -161,7 +241,13 @@ func NewKBucket(k int) *KBucket {
 func (self *KBucket) getFromList(contact Contact) *list.Element {
 	self.mu.Lock()
 	defer self.mu.Unlock()
-	for e := self.contacts.Front(); e != nil; e = e.Next() {
+	return findInList(self.contacts, contact)
+}
+
+// findInList returns the element of l holding contact, or nil. The caller must
+// hold the bucket's lock.
+func findInList(l *list.List, contact Contact) *list.Element {
+	for e := l.Front(); e != nil; e = e.Next() {
 		curr, _ := e.Value.(Contact)
 		// TODO: handle error when element can't be cast to Contact
 		if AreEqualContacts(&curr, &contact) {
@@ -175,7 +261,7 @@ func (self *KBucket) getFromList(contact Contact) *list.Element {
 func (self *KBucket) getAllContacts() []Contact {
 	self.mu.Lock()
 	defer self.mu.Unlock()
-	contacts := make([]Contact, 0, 20)
+	contacts := make([]Contact, 0, self.contacts.Len())
 	for e := self.contacts.Front(); e != nil; e = e.Next() {
 		curr, _ := e.Value.(Contact)
 		contacts = append(contacts, curr)
@@ -184,73 +270,151 @@ func (self *KBucket) getAllContacts() []Contact {
 }
 
 // Returns true if contact is added into bucket, false otherwise
+// Contacts that arrive while the bucket is full go into the replacement cache
+// (section 4.1), which fills the bucket back up as contacts are removed
 func (self *KBucket) addContact(contact Contact) bool {
-	// If contact exists, move to tail
-	element := self.getFromList(contact)
 	self.mu.Lock()
 	defer self.mu.Unlock()
+	// If contact exists, move to tail
+	element := findInList(self.contacts, contact)
 	if element != nil {
 		self.contacts.MoveToFront(element)
 		return true
+	}
+	// If bucket isn't full, add to tail
+	// list.Len() = O(1)
+	if self.contacts.Len() < self.k {
+		self.contacts.PushFront(contact)
+		return true
+	}
+
+	// Otherwise keep it as a replacement, most recently seen first
+	if cached := findInList(self.lruCache, contact); cached != nil {
+		self.lruCache.MoveToFront(cached)
 	} else {
-		// If bucket isn't full, add to tail
-		// list.Len() = O(1)
-		if self.contacts.Len() < self.k {
-			self.contacts.PushFront(contact)
-			return true
+		self.lruCache.PushFront(contact)
+		if self.lruCache.Len() > self.k {
+			oldest := self.lruCache.Remove(self.lruCache.Back()).(Contact)
+			delete(self.health, oldest.Addr.String())
 		}
-		/* TODO: Deal when with buckets are full
-		// Otherwise, ping least-recently seen node
-		lruNode := self.contacts.Front()
-		// ping node... sigh this is gnna be ugly.
-		if true {
-			// If no response, node is evicted and new sender is inserted at tail
-			self.contacts.Remove(lruNode)
-			self.contacts.PushBack(contact)
-			return true
-		}
-		// implement replacement cache
-		return false
-		*/
-		return false
 	}
+	return false
 }
 
 // ContactFromID returns the contact that belongs to id if it exists and nil if
 // it doesn't
-func (table *RoutingTable) ContactFromID(id big.Int) *Contact {
+func (table *RoutingTable) ContactFromID(id NodeID) *Contact {
 	contact := Contact{id, net.TCPAddr{}}
 
-	// find the bucket it should be in
-	// if the bucket has been allocated (isn't nil), see if it's
-	// in the list
-
-	index := table.owner.GetKBucketFromID(&id)
-	table.owner.logger.Printf("Index is %d", index)
-	kbucket := table.kBuckets[index]
-
-	if kbucket != nil {
-		table.owner.logger.Printf("Found a kbucket")
-		result := kbucket.getFromList(contact)
-		if result != nil {
-			toReturn := result.Value.(Contact)
-			return &toReturn
-		}
-	} else {
+	// find the bucket it should be in, and see if it's in the list
+	index := table.owner.GetKBucketFromID(id)
+	table.owner.logs.routing.Debugf("Index is %d", index)
+	if index < 0 {
 		return nil
 	}
+	result := table.kBuckets[index].getFromList(contact)
+	if result != nil {
+		toReturn := result.Value.(Contact)
+		return &toReturn
+	}
 	return nil
 }
 
 // Returns true if contact exists, false otherwise
+// The most recently seen contact in the replacement cache takes its place
 func (self *KBucket) removeContact(contact Contact) bool {
 	self.mu.Lock()
 	defer self.mu.Unlock()
-	element := self.getFromList(contact)
-	if element != nil {
-		self.contacts.Remove(element)
-		return true
-	} else {
+	return self.remove(contact)
+}
+
+// remove is removeContact for a caller that holds the lock
+func (self *KBucket) remove(contact Contact) bool {
+	delete(self.health, contact.Addr.String())
+	if cached := findInList(self.lruCache, contact); cached != nil {
+		self.lruCache.Remove(cached)
+	}
+	element := findInList(self.contacts, contact)
+	if element == nil {
 		return false
 	}
+	self.contacts.Remove(element)
+	if replacement := self.lruCache.Front(); replacement != nil {
+		self.contacts.PushFront(self.lruCache.Remove(replacement))
+	}
+	return true
+}
+
+// failContact counts a failed RPC to contact, and removes it if there is a
+// replacement waiting or it has failed staleLimit times in a row. Returns true
+// if it was removed.
+func (self *KBucket) failContact(contact Contact) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if findInList(self.contacts, contact) == nil {
+		// a replacement that doesn't answer isn't worth keeping
+		if cached := findInList(self.lruCache, contact); cached != nil {
+			self.lruCache.Remove(cached)
+			delete(self.health, contact.Addr.String())
+		}
+		return false
+	}
+	health := self.healthOf(contact)
+	health.failures++
+	if self.lruCache.Len() == 0 && health.failures < staleLimit {
+		return false
+	}
+	return self.remove(contact)
+}
+
+// seenContact records that we heard from contact, forgetting its failed RPCs.
+// rtt is how long it took to answer, or 0 if it contacted us.
+func (self *KBucket) seenContact(contact Contact, rtt time.Duration) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if findInList(self.contacts, contact) == nil && findInList(self.lruCache, contact) == nil {
+		return
+	}
+	health := self.healthOf(contact)
+	health.failures = 0
+	health.lastSeen = time.Now()
+	if rtt > 0 && health.rtt == 0 {
+		health.rtt = rtt
+	} else if rtt > 0 {
+		// weighted as TCP weights its round trip times (RFC 6298)
+		health.rtt += (rtt - health.rtt) / 8
+	}
+}
+
+// healthOf returns the health of contact, which the caller must hold the lock
+// for and have in the bucket or replacement cache
+func (self *KBucket) healthOf(contact Contact) *contactHealth {
+	addr := contact.Addr.String()
+	health := self.health[addr]
+	if health == nil {
+		health = &contactHealth{}
+		self.health[addr] = health
+	}
+	return health
+}
+
+// entry describes contact. The caller must hold the lock.
+func (self *KBucket) entry(contact Contact) routingEntry {
+	result := routingEntry{Contact: contact}
+	if health := self.health[contact.Addr.String()]; health != nil {
+		result.Failures = health.failures
+		result.LastSeen = health.lastSeen
+		result.RTT = health.rtt
+	}
+	return result
+}
+
+// entries describes the contacts in l, the bucket or its replacement cache.
+// The caller must hold the lock.
+func (self *KBucket) entries(l *list.List) []routingEntry {
+	result := make([]routingEntry, 0, l.Len())
+	for e := l.Front(); e != nil; e = e.Next() {
+		result = append(result, self.entry(e.Value.(Contact)))
+	}
+	return result
 }
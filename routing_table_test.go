@@ -0,0 +1,403 @@
+package kademlia
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"testing"
+	"time"
+)
+
+// failTransport fails every RPC, for nodes that never talk to anyone
+type failTransport struct{}
+
+func (failTransport) Call(method string, dest net.TCPAddr, args interface{}, reply interface{}) bool {
+	return false
+}
+func (failTransport) Go(fn func()) { fn() }
+func (failTransport) Parallel(n int, fn func(i int)) {
+	for i := 0; i < n; i++ {
+		fn(i)
+	}
+}
+
+// newTestNode returns a quiet node at address that can't reach anyone
+func newTestNode(t testing.TB, address string) *Node {
+	node := NewNodeWithTransport(address, DefaultConfig(), failTransport{})
+	if node == nil {
+		t.Fatalf("couldn't create node at %s", address)
+	}
+	node.SetLogger(log.New(ioutil.Discard, "", 0))
+	return node
+}
+
+// testContact returns the contact for the i-th test address
+func testContact(i int) Contact {
+	addr, _ := net.ResolveTCPAddr("tcp", fmt.Sprintf("10.0.%d.%d:4000", i/256, i%256))
+	return *NewContact(*addr)
+}
+
+// idBits returns the ID with only the given bits set, bit 0 being the lowest
+func idBits(set ...int) NodeID {
+	var id NodeID
+	for _, bit := range set {
+		id[len(id)-1-bit/8] |= 1 << uint(bit%8)
+	}
+	return id
+}
+
+func TestGetKBucketFromID(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	var all NodeID
+	for i := range all {
+		all[i] = 0xff
+	}
+	tests := []struct {
+		name string
+		mask NodeID
+		want int
+	}{
+		{"same ID", NodeID{}, -1},
+		{"lowest bit", idBits(0), 0},
+		{"second bit", idBits(1), 1},
+		{"two low bits", idBits(0, 1), 1},
+		{"bit 8", idBits(8), 8},
+		{"bits below 8", idBits(0, 1, 2, 3, 4, 5, 6, 7), 7},
+		{"bit 100", idBits(100), 100},
+		{"bits 100 and 3", idBits(100, 3), 100},
+		{"top bit", idBits(159), 159},
+		{"all bits", all, 159},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := node.GetKBucketFromID(node.id.Xor(test.mask)); got != test.want {
+				t.Errorf("GetKBucketFromID = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetKBucketFromAddr(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	for i := 0; i < 50; i++ {
+		contact := testContact(i)
+		if got, want := node.GetKBucketFromAddr(contact.Addr), node.GetKBucketFromID(contact.Id); got != want {
+			t.Errorf("%s: GetKBucketFromAddr = %d, GetKBucketFromID = %d", contact.Addr.String(), got, want)
+		}
+	}
+}
+
+func TestBucketRange(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	for _, index := range []int{0, 1, 7, 8, 100, 159} {
+		first, last := node.bucketRange(index)
+		if node.GetKBucketFromID(first) != index || node.GetKBucketFromID(last) != index {
+			t.Errorf("bucket %d runs from bucket %d to %d", index, node.GetKBucketFromID(first), node.GetKBucketFromID(last))
+		}
+		// the IDs in between differ in every bit below index
+		below := make([]int, index)
+		for bit := range below {
+			below[bit] = bit
+		}
+		if first.Xor(last) != idBits(below...) || first.Cmp(last) > 0 {
+			t.Errorf("bucket %d runs from %s to %s", index, first, last)
+		}
+	}
+}
+
+// closest returns the (at most) n contacts closest to target, by brute force
+func closest(contacts []Contact, target NodeID, n int) []Contact {
+	sorted := append([]Contact(nil), contacts...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return distanceBetween(target, sorted[i].Id).Cmp(distanceBetween(target, sorted[j].Id)) < 0
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+func sameContacts(a []Contact, b []Contact) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !AreEqualContacts(&a[i], &b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func addrs(contacts []Contact) []string {
+	result := make([]string, len(contacts))
+	for i, contact := range contacts {
+		result[i] = contact.Addr.String()
+	}
+	return result
+}
+
+func TestFindKNearestContacts(t *testing.T) {
+	tests := []struct {
+		name     string
+		contacts int
+	}{
+		{"empty table", 0},
+		{"fewer than k", k - 1},
+		{"exactly k", k},
+		{"a few buckets", 20},
+		{"many buckets", 500},
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := newTestNode(t, "10.1.0.1:4000")
+			var added []Contact
+			for i := 0; i < test.contacts; i++ {
+				contact := testContact(i)
+				node.rt.add(contact)
+			}
+			// only the contacts that fit in their buckets are known
+			added = node.rt.contacts()
+
+			targets := []NodeID{node.id}
+			for i := 0; i < 160; i += 7 {
+				// a target in each bucket, just past the boundary
+				targets = append(targets, node.id.Xor(idBits(i)))
+			}
+			for i := 0; i < 50; i++ {
+				var target NodeID
+				r.Read(target[:])
+				targets = append(targets, target)
+			}
+			for _, target := range targets {
+				got := node.rt.findKNearestContacts(target)
+				want := closest(added, target, k)
+				if !sameContacts(got, want) {
+					t.Errorf("target %s: got %v, want %v", target, addrs(got), addrs(want))
+				}
+			}
+		})
+	}
+}
+
+// bucketContacts returns contacts that all fall in the same bucket of node
+func bucketContacts(node *Node, n int) []Contact {
+	var contacts []Contact
+	buckets := make(map[int][]Contact)
+	for i := 0; len(contacts) == 0; i++ {
+		contact := testContact(i)
+		index := node.GetKBucketFromID(contact.Id)
+		buckets[index] = append(buckets[index], contact)
+		if len(buckets[index]) == n {
+			contacts = buckets[index]
+		}
+	}
+	return contacts
+}
+
+func TestKBucketAddContact(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	contacts := bucketContacts(node, k+2)
+
+	tests := []struct {
+		name string
+		// add is the order contacts are added in, by index into contacts
+		add []int
+		// want is the bucket from most to least recently seen, and cache
+		// the replacement cache
+		want  []int
+		cache []int
+		added []bool
+	}{
+		{"one", []int{0}, []int{0}, nil, []bool{true}},
+		{"fill", []int{0, 1, 2, 3}, []int{3, 2, 1, 0}, nil, []bool{true, true, true, true}},
+		{"seen again", []int{0, 1, 0}, []int{0, 1}, nil, []bool{true, true, true}},
+		{"full", []int{0, 1, 2, 3, 4}, []int{3, 2, 1, 0}, []int{4}, []bool{true, true, true, true, false}},
+		{"seen while full", []int{0, 1, 2, 3, 4, 1}, []int{1, 3, 2, 0}, []int{4}, []bool{true, true, true, true, false, true}},
+		{"replacements", []int{0, 1, 2, 3, 4, 5, 4}, []int{3, 2, 1, 0}, []int{4, 5}, []bool{true, true, true, true, false, false, false}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bucket := NewKBucket(k)
+			for i, c := range test.add {
+				if got := bucket.addContact(contacts[c]); got != test.added[i] {
+					t.Errorf("adding contact %d (step %d) returned %t, want %t", c, i, got, test.added[i])
+				}
+			}
+			checkList(t, "bucket", bucket.getAllContacts(), contacts, test.want)
+			checkList(t, "replacement cache", listContacts(bucket), contacts, test.cache)
+		})
+	}
+}
+
+func TestKBucketReplacementCacheIsBounded(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	contacts := bucketContacts(node, 3*k)
+	bucket := NewKBucket(k)
+	for _, contact := range contacts {
+		bucket.addContact(contact)
+	}
+	cache := listContacts(bucket)
+	if len(cache) != k {
+		t.Fatalf("replacement cache has %d contacts, want %d", len(cache), k)
+	}
+	// the most recently seen are kept
+	want := []int{3*k - 1, 3*k - 2, 3*k - 3, 3*k - 4}
+	checkList(t, "replacement cache", cache, contacts, want)
+}
+
+func TestKBucketEviction(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	contacts := bucketContacts(node, k+2)
+
+	t.Run("remove promotes replacement", func(t *testing.T) {
+		bucket := NewKBucket(k)
+		for _, contact := range contacts {
+			bucket.addContact(contact)
+		}
+		if !bucket.removeContact(contacts[1]) {
+			t.Fatal("removeContact returned false for a contact in the bucket")
+		}
+		checkList(t, "bucket", bucket.getAllContacts(), contacts, []int{5, 3, 2, 0})
+		checkList(t, "replacement cache", listContacts(bucket), contacts, []int{4})
+		if bucket.removeContact(contacts[1]) {
+			t.Error("removeContact returned true for a contact no longer in the bucket")
+		}
+	})
+
+	t.Run("failure with replacement", func(t *testing.T) {
+		bucket := NewKBucket(k)
+		for _, contact := range contacts[:k+1] {
+			bucket.addContact(contact)
+		}
+		if !bucket.failContact(contacts[0]) {
+			t.Fatal("contact wasn't evicted with a replacement waiting")
+		}
+		checkList(t, "bucket", bucket.getAllContacts(), contacts, []int{4, 3, 2, 1})
+	})
+
+	t.Run("failures without replacement", func(t *testing.T) {
+		bucket := NewKBucket(k)
+		for _, contact := range contacts[:k] {
+			bucket.addContact(contact)
+		}
+		for i := 1; i < staleLimit; i++ {
+			if bucket.failContact(contacts[0]) {
+				t.Fatalf("contact evicted after %d failures, before staleLimit", i)
+			}
+		}
+		if !bucket.failContact(contacts[0]) {
+			t.Fatalf("contact not evicted after %d failures", staleLimit)
+		}
+		checkList(t, "bucket", bucket.getAllContacts(), contacts, []int{3, 2, 1})
+	})
+
+	t.Run("answering clears failures", func(t *testing.T) {
+		bucket := NewKBucket(k)
+		for _, contact := range contacts[:k] {
+			bucket.addContact(contact)
+		}
+		for i := 0; i < 2*staleLimit; i++ {
+			if bucket.failContact(contacts[0]) {
+				t.Fatalf("contact evicted after %d failures with answers in between", i+1)
+			}
+			if i%2 == 1 {
+				bucket.seenContact(contacts[0], 0)
+			}
+		}
+	})
+
+	t.Run("routing table", func(t *testing.T) {
+		node := newTestNode(t, "10.1.0.1:4000")
+		for _, contact := range contacts {
+			node.rt.add(contact)
+		}
+		node.rt.failed(contacts[0])
+		if node.rt.ContactFromID(contacts[0].Id) != nil {
+			t.Error("failed contact is still in the routing table")
+		}
+		if node.rt.ContactFromID(contacts[5].Id) == nil {
+			t.Error("replacement wasn't moved into the routing table")
+		}
+		// removing from a bucket that was never created does nothing
+		node.rt.failed(testContact(10000))
+		node.rt.remove(testContact(10000))
+	})
+}
+
+func TestKBucketHealth(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	contacts := bucketContacts(node, 3*k)
+	bucket := NewKBucket(k)
+	for _, contact := range contacts[:k+1] {
+		bucket.addContact(contact)
+	}
+	entry := func(contact Contact) routingEntry {
+		bucket.mu.Lock()
+		defer bucket.mu.Unlock()
+		return bucket.entry(contact)
+	}
+
+	if e := entry(contacts[0]); !e.LastSeen.IsZero() || e.RTT != 0 || e.Failures != 0 {
+		t.Errorf("contact that was only added has %+v", e)
+	}
+	bucket.seenContact(contacts[0], 8*time.Millisecond)
+	bucket.seenContact(contacts[0], 16*time.Millisecond)
+	bucket.seenContact(contacts[0], 0)
+	if e := entry(contacts[0]); e.LastSeen.IsZero() || e.RTT != 9*time.Millisecond {
+		t.Errorf("contact seen three times has %+v, want an RTT of 9ms", e)
+	}
+	// the replacement in the cache is tracked too
+	bucket.seenContact(contacts[k], time.Millisecond)
+	if e := entry(contacts[k]); e.RTT != time.Millisecond {
+		t.Errorf("replacement has %+v", e)
+	}
+	// but not contacts the bucket doesn't hold
+	bucket.seenContact(contacts[k+1], time.Millisecond)
+	if e := entry(contacts[k+1]); !e.LastSeen.IsZero() {
+		t.Errorf("contact outside the bucket has %+v", e)
+	}
+
+	bucket.failContact(contacts[k])
+	if e := entry(contacts[k]); !e.LastSeen.IsZero() {
+		t.Errorf("failed replacement wasn't forgotten: %+v", e)
+	}
+	// contacts pushed out of the replacement cache are forgotten
+	for _, contact := range contacts[k+1:] {
+		bucket.addContact(contact)
+		bucket.seenContact(contact, time.Millisecond)
+	}
+	bucket.mu.Lock()
+	tracked := len(bucket.health)
+	bucket.mu.Unlock()
+	if tracked > 2*k {
+		t.Errorf("bucket tracks %d contacts, more than it and its cache hold", tracked)
+	}
+}
+
+// listContacts returns the replacement cache of bucket
+func listContacts(bucket *KBucket) []Contact {
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	var result []Contact
+	for e := bucket.lruCache.Front(); e != nil; e = e.Next() {
+		result = append(result, e.Value.(Contact))
+	}
+	return result
+}
+
+// checkList checks that got holds the contacts at indexes want, in order
+func checkList(t *testing.T, what string, got []Contact, contacts []Contact, want []int) {
+	t.Helper()
+	wantContacts := make([]Contact, len(want))
+	for i, index := range want {
+		wantContacts[i] = contacts[index]
+	}
+	if !sameContacts(got, wantContacts) {
+		t.Errorf("%s is %v, want %v", what, addrs(got), addrs(wantContacts))
+	}
+}
@@ -28,6 +28,12 @@ func (fakeNode *NodeRPC) FindNode(args FindNodeArgs, reply *FindNodeReply) error
 	return nil
 }
 
+// Delete is a stub function that exposes the DELETE RPC
+func (fakeNode *NodeRPC) Delete(args DeleteArgs, reply *DeleteReply) error {
+	fakeNode.node.Delete(args, reply)
+	return nil
+}
+
 // NodeRPC is a wrapper struct that is used to control which RPCs are exposed
 type NodeRPC struct {
 	node *Node
@@ -0,0 +1,148 @@
+package kademlia
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func gobEncode(t testing.TB, v interface{}) []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// FuzzRPCArgs decodes arbitrary bytes as the arguments to each RPC, the way
+// net/rpc does, and hands whatever decodes to the node. No input may crash it.
+func FuzzRPCArgs(f *testing.F) {
+	source := net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4000}
+	key := ContentKey([]byte("value"))
+	secret := []byte("secret")
+	cas := int64(3)
+	seeds := []interface{}{
+		PingArgs{Source: source},
+		StoreArgs{Source: source, Key: key, Val: []byte("value"), Published: time.Now()},
+		StoreArgs{Source: source, Key: key, Val: []byte("value"), Immutable: true, TTL: time.Hour, OwnerHash: OwnerHash(secret)},
+		StoreArgs{Source: source, Key: NameKey("name"), Val: []byte("value"), Name: "name", Cached: true},
+		StoreArgs{Source: source, Key: key, Mutable: &MutableRecord{Seq: 1}, Cas: &cas},
+		StoreArgs{Source: source, Key: "not hex"},
+		FindValueArgs{Source: source, Key: key},
+		FindValueArgs{Source: source, Key: ""},
+		FindNodeArgs{Source: source, Key: key},
+		FindNodeArgs{Source: source, Key: "zz"},
+		DeleteArgs{Source: source, Key: key, Secret: secret, Deleted: time.Now()},
+		DeleteArgs{Source: source, Key: key, Mutable: &MutableRecord{Seq: 2}},
+	}
+	for _, seed := range seeds {
+		data := gobEncode(f, seed)
+		for method := byte(0); method < 5; method++ {
+			f.Add(method, data)
+		}
+	}
+
+	node := newTestNode(f, "10.0.0.1:4000")
+	rpc := &NodeRPC{node}
+	f.Fuzz(func(t *testing.T, method byte, data []byte) {
+		decode := func(v interface{}) bool {
+			return gob.NewDecoder(bytes.NewReader(data)).Decode(v) == nil
+		}
+		switch method % 5 {
+		case 0:
+			var args PingArgs
+			if decode(&args) {
+				rpc.Ping(args, &PingReply{})
+			}
+		case 1:
+			var args StoreArgs
+			if decode(&args) {
+				rpc.Store(args, &StoreReply{})
+			}
+		case 2:
+			var args FindValueArgs
+			if decode(&args) {
+				rpc.FindValue(args, &FindValueReply{})
+			}
+		case 3:
+			var args FindNodeArgs
+			if decode(&args) {
+				rpc.FindNode(args, &FindNodeReply{})
+			}
+		case 4:
+			var args DeleteArgs
+			if decode(&args) {
+				rpc.Delete(args, &DeleteReply{})
+			}
+		}
+	})
+}
+
+func TestUnverifiedTombstones(t *testing.T) {
+	nodes, _ := chain(t, 2)
+	node := nodes[0]
+	node.config.MaxKeysPerPublisher = 2
+	source := testContact(9).Addr
+	secret := []byte("secret")
+
+	// deletes of keys we never held count against whoever sent them
+	for i := 0; i < 3; i++ {
+		key := ContentKey([]byte{byte(i)})
+		err := node.Delete(DeleteArgs{Source: source, Key: key, Secret: secret}, &DeleteReply{})
+		if i < 2 && err != nil {
+			t.Fatalf("delete %d failed: %s", i, err)
+		}
+		if i == 2 && err == nil {
+			t.Error("delete past the publisher limit succeeded")
+		}
+	}
+	if keys, bytes := node.ht.size(); keys != 2 || bytes == 0 {
+		t.Errorf("store holds %d keys taking %d bytes", keys, bytes)
+	}
+
+	// a delete we could check the secret of is replicated, the others aren't
+	verified := ContentKey([]byte("value"))
+	node.ht.put(&KV{key: verified, val: []byte("value"), ownerHash: OwnerHash(secret), expires: time.Now().Add(time.Hour)})
+	if err := node.Delete(DeleteArgs{Source: source, Key: verified, Secret: secret}, &DeleteReply{}); err != nil {
+		t.Fatal(err)
+	}
+	node.doReplicate()
+	if keys, _ := nodes[1].ht.size(); keys != 1 {
+		t.Errorf("replication passed on %d tombstones", keys)
+	}
+	if kv, ok := nodes[1].ht.getKV(verified); !ok || !kv.tombstone {
+		t.Errorf("verified tombstone wasn't replicated: %+v", kv)
+	}
+}
+
+func TestStorePublisher(t *testing.T) {
+	node := newTestNode(t, "10.1.0.1:4000")
+	node.config.MaxKeysPerPublisher = 2
+	source := testContact(9).Addr
+	store := func(i int, source net.TCPAddr, publisher string) error {
+		val := []byte{byte(i)}
+		return node.Store(StoreArgs{Source: source, Key: ContentKey(val), Val: val, Publisher: publisher}, &StoreReply{})
+	}
+
+	// a peer is charged for its stores, whoever it claims they're for
+	for i := 0; i < 3; i++ {
+		err := store(i, source, fmt.Sprintf("10.9.9.%d", i))
+		if (err == nil) != (i < 2) {
+			t.Errorf("store %d from a peer returned %v", i, err)
+		}
+	}
+	if kv, _ := node.ht.getKV(ContentKey([]byte{0})); kv.publisher != source.String() {
+		t.Errorf("peer's store charged to %s", kv.publisher)
+	}
+
+	// while our own stores are charged to whoever we publish them for
+	if err := store(3, node.addr, "10.9.9.9"); err != nil {
+		t.Fatal(err)
+	}
+	if kv, _ := node.ht.getKV(ContentKey([]byte{3})); kv.publisher != "10.9.9.9" {
+		t.Errorf("our own store charged to %s", kv.publisher)
+	}
+}
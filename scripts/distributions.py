@@ -26,16 +26,16 @@ class Uniform:
         return random.choice(self.arr)
 
 class Linear:
+    # weights fall in a straight line from 1 for the first key to 1/m for the
+    # last, so m = 1 is uniform
     def __init__(self, arr, m):
         n = len(arr)
-        distMap = [1] * len(n)
-        distMap[-1] = 1/m
-        change = (distMap[0] - distMap[-1]) / m
-
-        distMap = [(x - change) / distMap[-1] for x in distMap]
+        step = (1 - 1. / m) / (n - 1) if n > 1 else 0
+        tmp = [1 - step * i for i in range(n)]
+        sums = functools.reduce(lambda sums, x: sums + [sums[-1] + x], tmp, [0])
 
         self.arr = arr
-        self.distMap = distMap
+        self.distMap = [x / sums[-1] for x in sums]
 
     def next(self):
         u = random.random()
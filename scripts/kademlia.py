@@ -11,11 +11,35 @@ class KademliaNode:
     def __init__(self, address):
         self.address = address
 
-    def store_here(self, key, value):
-        requests.post("http://{}/store_here/{}".format(self.address, key), data=value)
-
-    def store(self, key, value):
-        requests.post("http://{}/store/{}".format(self.address, key), data=value)
+    def store_here(self, key, value, ttl=None):
+        params = {'ttl': ttl} if ttl else None
+        requests.post("http://{}/store_here/{}".format(self.address, key), data=value, params=params)
+
+    def store(self, key, value, secret=None, ttl=None):
+        params = {}
+        if secret:
+            params['secret'] = secret
+        if ttl:
+            params['ttl'] = ttl
+        requests.post("http://{}/store/{}".format(self.address, key), data=value, params=params)
+
+    def store_name(self, name, value, secret=None, ttl=None):
+        params = {}
+        if secret:
+            params['secret'] = secret
+        if ttl:
+            params['ttl'] = ttl
+        requests.post("http://{}/name/store/{}".format(self.address, name), data=value, params=params)
+
+    def findname(self, name):
+        r = requests.get("http://{}/name/findvalue/{}".format(self.address, name))
+        value = json.loads(r.text)
+        if value is None:
+            return None
+        return base64.b64decode(value)
+
+    def delete(self, key, secret):
+        requests.delete("http://{}/store/{}".format(self.address, key), params={'secret': secret})
 
     def ping(self, target, byid):
         if byid:
@@ -33,7 +57,7 @@ class KademliaNode:
             except:
                 pass
 
-    def findnode(self, target, oneshot):
+    def findnode(self, target, oneshot, via=None):
         if oneshot:
             method = "oneshot"
         else:
@@ -41,14 +65,14 @@ class KademliaNode:
 
         url = "http://{}/{}/findnode/{}".format(self.address, method, target)
 
-        r = requests.get(url)
+        r = requests.get(url, params={'node': via} if oneshot else None)
         contacts = json.loads(r.text)
         for entry in contacts:
             key = entry['Id']
             addr = entry['Addr']
-            print("%x %s"%(key, addr))
+            print("%s %s"%(key, addr))
 
-    def findvalue(self, key, oneshot=False):
+    def findvalue(self, key, oneshot=False, via=None):
         if oneshot:
             method = "oneshot"
         else:
@@ -56,7 +80,13 @@ class KademliaNode:
 
         url = "http://{}/{}/findvalue/{}".format(self.address, method, key)
 
-        r = requests.get(url)
+        r = requests.get(url, params={'node': via} if oneshot else None)
+
+        if oneshot:
+            reply = json.loads(r.text)
+            if reply['value'] is None:
+                return None
+            return base64.b64decode(reply['value'])
 
         return base64.b64decode(json.loads(r.text))
 
@@ -4,14 +4,16 @@
 Usage:
     test.py <addr> store <key>
     test.py <addr> ping (id | ip) <target> 
-    test.py <addr> findnode (iterative | oneshot) <target>
-    test.py <addr> findvalue (iterative | oneshot) <target>
+    test.py <addr> findnode (iterative | oneshot <via>) <target>
+    test.py <addr> findvalue (iterative | oneshot <via>) <target>
     test.py <addr> shutdown
     test.py <addr> table
     test.py test [--zipf <alpha> | --uniform | --linear <m>] [--times <times>] [--keys <keys>] [--size <size>] [--storeonly | --retrieveonly ] <nodes>...
     test.py (-h | --help)
     test.py --version
 
+    <via> is the node a oneshot request is sent to, as host:port.
+
     Options:
         --zipf <alpha>              Use zipfian distribution
         --uniform                   Use uniform distribution (--linear 1)
@@ -75,11 +77,11 @@ if __name__ == '__main__':
     elif arguments['findnode']:
         key = key_to_id(arguments['<target>'])
         print("Going to find node with id: {}".format(key))
-        node.findnode(key, arguments['oneshot'])
+        node.findnode(key, arguments['oneshot'], arguments['<via>'])
     elif arguments['findvalue']:
         key = key_to_id(arguments['<target>'])
         print("Looking for value with id: {}".format(key))
-        value = node.findvalue(key, arguments['oneshot'])
+        value = node.findvalue(key, arguments['oneshot'], arguments['<via>'])
         print("Got value: {}".format(value))
     elif arguments['shutdown']:
         print("Attempting to shut down node")
@@ -0,0 +1,185 @@
+package sim
+
+import (
+	"bytes"
+	"sort"
+	"time"
+)
+
+// Crash stops node i without warning. Everything it held is lost and RPCs to
+// it time out.
+func (s *Simulator) Crash(i int) {
+	s.down[s.addrs[i]] = true
+}
+
+// Leave stops node i gracefully: it hands the values it holds on to the k
+// closest other nodes first
+func (s *Simulator) Leave(i int) {
+	start := s.now
+	s.nodes[i].Leave()
+	s.now = start
+	s.down[s.addrs[i]] = true
+}
+
+// Alive reports whether node i hasn't crashed or left
+func (s *Simulator) Alive(i int) bool {
+	return !s.down[s.addrs[i]]
+}
+
+// Live returns the indexes of the nodes that haven't crashed or left
+func (s *Simulator) Live() []int {
+	live := make([]int, 0, len(s.nodes))
+	for i := range s.nodes {
+		if s.Alive(i) {
+			live = append(live, i)
+		}
+	}
+	return live
+}
+
+// Pick returns n live nodes chosen at random, or all of them if there are
+// fewer than n
+func (s *Simulator) Pick(n int) []int {
+	live := s.Live()
+	s.rand.Shuffle(len(live), func(i, j int) {
+		live[i], live[j] = live[j], live[i]
+	})
+	if n < len(live) {
+		live = live[:n]
+	}
+	sort.Ints(live)
+	return live
+}
+
+// Partition splits the network so that messages only get through between
+// nodes in the same group. Nodes not in any group, including ones added
+// later, form a group of their own.
+func (s *Simulator) Partition(groups ...[]int) {
+	s.groups = make(map[string]int)
+	for g, group := range groups {
+		for _, i := range group {
+			s.groups[s.addrs[i]] = g + 1
+		}
+	}
+}
+
+// Heal removes the partition
+func (s *Simulator) Heal() {
+	s.groups = nil
+}
+
+// reachable reports whether a message from src can get to dst
+func (s *Simulator) reachable(src string, dst string) bool {
+	if s.down[dst] {
+		return false
+	}
+	return s.groups == nil || s.groups[src] == s.groups[dst]
+}
+
+// Every runs fn every interval from now until end
+func (s *Simulator) Every(interval time.Duration, end time.Duration, fn func()) {
+	for at := s.now + interval; at <= end; at += interval {
+		s.Schedule(at, fn)
+	}
+}
+
+// ReplicateAll has every live node perform a round of replication, all
+// starting at the current time
+func (s *Simulator) ReplicateAll() {
+	start := s.now
+	end := start
+	for _, i := range s.Live() {
+		s.now = start
+		s.nodes[i].Replicate()
+		if s.now > end {
+			end = s.now
+		}
+	}
+	s.now = end
+}
+
+// Sample is a measurement of the health of the network
+type Sample struct {
+	At   time.Duration
+	Live int
+	// LookupSuccess is the fraction of lookups that found the right value
+	LookupSuccess float64
+	// Durability is the fraction of keys still held by at least one live node
+	Durability float64
+	// Staleness is the fraction of routing table entries on live nodes that
+	// point at nodes that have crashed or left
+	Staleness float64
+	// Hops and Latency are the means over the lookups that succeeded
+	Hops    float64
+	Latency time.Duration
+}
+
+// Measure samples the network. Durability and staleness are read straight
+// from the live nodes before anything else happens. Then lookups of keys chosen
+// at random from data are made from random live nodes, each starting at the
+// current time, and the clock is put back afterwards.
+func (s *Simulator) Measure(data map[string][]byte, lookups int) Sample {
+	live := s.Live()
+	sample := Sample{At: s.now, Live: len(live)}
+	if len(live) == 0 {
+		return sample
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	held := 0
+	for _, key := range keys {
+		for _, i := range live {
+			if s.nodes[i].Holds(key) {
+				held++
+				break
+			}
+		}
+	}
+	if len(keys) > 0 {
+		sample.Durability = float64(held) / float64(len(keys))
+	}
+
+	entries, stale := 0, 0
+	for _, i := range live {
+		for _, contact := range s.nodes[i].Contacts() {
+			entries++
+			if s.down[contact.Addr.String()] {
+				stale++
+			}
+		}
+	}
+	if entries > 0 {
+		sample.Staleness = float64(stale) / float64(entries)
+	}
+
+	if len(keys) == 0 || lookups == 0 {
+		return sample
+	}
+	start := s.now
+	found := 0
+	var hops int
+	var latency time.Duration
+	for n := 0; n < lookups; n++ {
+		key := keys[s.rand.Intn(len(keys))]
+		from := live[s.rand.Intn(len(live))]
+		s.now = start
+		result := s.FindValue(from, key)
+		if bytes.Equal(result.Value, data[key]) {
+			found++
+			hops += result.Stats.Rounds
+			latency += result.Latency
+		}
+	}
+	s.now = start
+	sample.LookupSuccess = float64(found) / float64(lookups)
+	if found > 0 {
+		sample.Hops = float64(hops) / float64(found)
+		sample.Latency = latency / time.Duration(found)
+	}
+	return sample
+}
@@ -0,0 +1,197 @@
+package sim
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var seed = flag.Int64("seed", 1, "seed for the simulated networks")
+
+const (
+	churnNodes   = 100
+	churnKeys    = 50
+	churnLookups = 100
+)
+
+// churnNetwork builds a network of churnNodes nodes holding churnKeys keys
+func churnNetwork(t *testing.T) (*Simulator, map[string][]byte) {
+	s := New(Config{Seed: *seed, Latency: Uniform(10*time.Millisecond, 100*time.Millisecond)})
+	s.AddNodes(churnNodes)
+	data := make(map[string][]byte)
+	for i := 0; i < churnKeys; i++ {
+		hash := sha1.Sum([]byte(strconv.Itoa(i)))
+		key := hex.EncodeToString(hash[:])
+		data[key] = []byte(fmt.Sprintf("value %d", i))
+		if acks, err := s.Store(s.Pick(1)[0], key, data[key]); acks == 0 {
+			t.Fatalf("storing %s: %s", key, err)
+		}
+	}
+	return s, data
+}
+
+func logSamples(t *testing.T, samples []Sample) {
+	t.Logf("%8s %5s %8s %10s %9s %5s %9s", "time", "live", "lookups", "durability", "staleness", "hops", "latency")
+	for _, sample := range samples {
+		t.Logf("%8s %5d %8.2f %10.2f %9.2f %5.2f %9s", sample.At, sample.Live, sample.LookupSuccess,
+			sample.Durability, sample.Staleness, sample.Hops, sample.Latency.Round(time.Millisecond))
+	}
+}
+
+// TestChurn runs each script against a fresh network, sampling it every 30
+// simulated seconds, and checks the last sample. Run with -v to see the samples
+// and -seed to try other networks.
+func TestChurn(t *testing.T) {
+	tests := []struct {
+		name string
+		// script is run at the start of each simulated minute
+		script []func(s *Simulator)
+		// replicate is set if live nodes republish every minute
+		replicate bool
+		// minimum lookup success and durability at the end
+		lookups    float64
+		durability float64
+	}{
+		{
+			name: "joins",
+			script: []func(s *Simulator){
+				func(s *Simulator) { s.AddNodes(50) },
+				func(s *Simulator) { s.AddNodes(50) },
+			},
+			lookups:    0.95,
+			durability: 1,
+		},
+		{
+			name: "graceful leaves",
+			script: []func(s *Simulator){
+				func(s *Simulator) { leave(s, 20) },
+				func(s *Simulator) { leave(s, 20) },
+			},
+			lookups:    0.95,
+			durability: 1,
+		},
+		{
+			name: "crashes",
+			script: []func(s *Simulator){
+				func(s *Simulator) { crash(s, 20) },
+				func(s *Simulator) { crash(s, 20) },
+			},
+			replicate:  true,
+			lookups:    0.9,
+			durability: 0.9,
+		},
+		{
+			name: "joins and crashes",
+			script: []func(s *Simulator){
+				func(s *Simulator) { s.AddNodes(20); crash(s, 20) },
+				func(s *Simulator) { s.AddNodes(20); crash(s, 20) },
+				func(s *Simulator) { s.AddNodes(20); crash(s, 20) },
+			},
+			replicate:  true,
+			lookups:    0.9,
+			durability: 0.9,
+		},
+		{
+			name: "partition",
+			script: []func(s *Simulator){
+				func(s *Simulator) {
+					live := s.Live()
+					s.Partition(live[:len(live)/2], live[len(live)/2:])
+				},
+				func(s *Simulator) { s.Heal() },
+			},
+			replicate:  true,
+			lookups:    0.95,
+			durability: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, data := churnNetwork(t)
+			start := s.Now()
+			end := start + time.Duration(len(test.script)+1)*time.Minute
+			for i, step := range test.script {
+				step := step
+				s.Schedule(start+time.Duration(i)*time.Minute+time.Second, func() { step(s) })
+			}
+			if test.replicate {
+				// halfway through each minute, between the steps
+				for at := start + 30*time.Second; at < end; at += time.Minute {
+					s.Schedule(at+time.Second, s.ReplicateAll)
+				}
+			}
+			var samples []Sample
+			s.Every(30*time.Second, end, func() {
+				samples = append(samples, s.Measure(data, churnLookups))
+			})
+			samples = append(samples, s.Measure(data, churnLookups))
+			s.RunUntil(end)
+
+			logSamples(t, samples)
+			last := samples[len(samples)-1]
+			if last.LookupSuccess < test.lookups {
+				t.Errorf("lookup success %.2f, want at least %.2f", last.LookupSuccess, test.lookups)
+			}
+			if last.Durability < test.durability {
+				t.Errorf("durability %.2f, want at least %.2f", last.Durability, test.durability)
+			}
+		})
+	}
+}
+
+// TestCrashStaleness checks that crashed nodes leave stale routing table
+// entries behind and that lookups clear them out
+func TestCrashStaleness(t *testing.T) {
+	s, data := churnNetwork(t)
+	if sample := s.Measure(data, 0); sample.Staleness != 0 {
+		t.Fatalf("staleness %.2f before any crashes", sample.Staleness)
+	}
+	crash(s, churnNodes/4)
+	first := s.Measure(data, churnLookups*5)
+	if first.Staleness == 0 {
+		t.Fatalf("no stale entries after crashing %d nodes", churnNodes/4)
+	}
+	second := s.Measure(data, 0)
+	if second.Staleness >= first.Staleness {
+		t.Errorf("staleness went from %.2f to %.2f after %d lookups", first.Staleness, second.Staleness, churnLookups*5)
+	}
+}
+
+// TestDeterministic checks that a network built from the same seed behaves
+// the same way
+func TestDeterministic(t *testing.T) {
+	run := func() (Sample, Stats, time.Duration) {
+		s, data := churnNetwork(t)
+		crash(s, 10)
+		sample := s.Measure(data, churnLookups)
+		return sample, s.Stats(), s.Now()
+	}
+	firstSample, firstStats, firstNow := run()
+	secondSample, secondStats, secondNow := run()
+	if firstSample != secondSample {
+		t.Errorf("sample differs between runs: %+v and %+v", firstSample, secondSample)
+	}
+	if firstStats != secondStats {
+		t.Errorf("stats differ between runs: %+v and %+v", firstStats, secondStats)
+	}
+	if firstNow != secondNow {
+		t.Errorf("runs ended at %s and %s", firstNow, secondNow)
+	}
+}
+
+func leave(s *Simulator, n int) {
+	for _, i := range s.Pick(n) {
+		s.Leave(i)
+	}
+}
+
+func crash(s *Simulator, n int) {
+	for _, i := range s.Pick(n) {
+		s.Crash(i)
+	}
+}
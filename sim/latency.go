@@ -0,0 +1,87 @@
+package sim
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+// Latency returns the one-way delay of a message, drawing any randomness it
+// needs from r
+type Latency func(r *rand.Rand) time.Duration
+
+// Constant is a fixed delay
+func Constant(d time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		return d
+	}
+}
+
+// Uniform is a delay uniformly distributed between min and max
+func Uniform(min time.Duration, max time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+// Normal is a normally distributed delay, never less than zero
+func Normal(mean time.Duration, stddev time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		d := mean + time.Duration(r.NormFloat64()*float64(stddev))
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+}
+
+// Exponential is a delay of min plus an exponentially distributed tail with
+// the given mean, a rough model of queueing on a busy path
+func Exponential(min time.Duration, mean time.Duration) Latency {
+	return func(r *rand.Rand) time.Duration {
+		return min + time.Duration(r.ExpFloat64()*float64(mean))
+	}
+}
+
+// splitMix is a small, fast rand.Source so that a generator can be made for
+// every message
+type splitMix struct {
+	state uint64
+}
+
+func (s *splitMix) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *splitMix) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+func (s *splitMix) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+// hashRand returns a generator seeded from the hash of seed and parts. Deriving
+// randomness this way, rather than from one shared generator, keeps a message's
+// fate independent of how many other messages were sent before it.
+func hashRand(seed int64, n uint64, parts ...string) *rand.Rand {
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(seed))
+	h.Write(buf[:])
+	binary.LittleEndian.PutUint64(buf[:], n)
+	h.Write(buf[:])
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return rand.New(&splitMix{h.Sum64()})
+}
@@ -0,0 +1,250 @@
+// Package sim is a discrete-event simulator for networks of Kademlia nodes.
+//
+// Nodes run in-process over a virtual transport: every RPC is gob encoded as
+// it would be on the wire, handed straight to the destination node, and
+// charged to a simulated clock using the configured latency, loss and
+// bandwidth. Lookups that would send alpha RPCs at once are run one RPC at a
+// time from the same start time, so a run is fully determined by its seed.
+//
+// A typical experiment builds a network, stores some keys and measures
+// lookups:
+//
+//	s := sim.New(sim.Config{Seed: 1, Latency: sim.Uniform(10*time.Millisecond, 50*time.Millisecond)})
+//	s.AddNodes(1000)
+//	s.Store(0, key, value)
+//	result := s.FindValue(500, key)
+//	fmt.Println(result.Stats.Rounds, result.Latency)
+//
+// Nodes can be crashed, made to leave gracefully, or cut off from each other
+// with Partition, and Measure reports lookup success, durability and routing
+// table staleness at any point. TestChurn scripts these against a network of
+// 100 nodes; run it with
+//
+//	go test ./sim -run Churn -v -seed 7
+//
+// The simulator is single-threaded. Code paths that start their own
+// goroutines rather than going through the transport (large values and
+// erasure coding) aren't supported. Stored values still expire on the wall
+// clock, which doesn't matter for experiments measured in simulated seconds.
+package sim
+
+import (
+	"container/heap"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/peterdelong/kademlia"
+)
+
+// Config describes the simulated network
+type Config struct {
+	// Seed determines every random choice the simulator makes
+	Seed int64
+	// Latency is the one-way delay of each message, 10ms if nil
+	Latency Latency
+	// Loss is the probability each message is dropped
+	Loss float64
+	// Bandwidth of each link in bytes per second, unlimited if zero
+	Bandwidth float64
+	// Timeout is how long an RPC whose request or reply was lost takes to
+	// fail, one second if zero
+	Timeout time.Duration
+	// Node is the configuration of every node, kademlia.DefaultConfig() if
+	// zero
+	Node kademlia.Config
+}
+
+// Stats counts the traffic sent through the simulated network
+type Stats struct {
+	RPCs     int
+	Failed   int
+	Messages int
+	Lost     int
+	Bytes    int64
+}
+
+// Result is the outcome of a simulated lookup
+type Result struct {
+	// Value is nil if the value wasn't found
+	Value []byte
+	Stats kademlia.LookupStats
+	// Latency is how long the lookup took in simulated time
+	Latency time.Duration
+}
+
+// Simulator holds a simulated network of nodes
+type Simulator struct {
+	config Config
+	now    time.Duration
+	events eventQueue
+	seq    uint64
+
+	nodes  []*kademlia.Node
+	addrs  []string
+	byAddr map[string]*kademlia.Node
+	// links counts the messages sent over each (source, destination) link
+	links map[[2]string]uint64
+	stats Stats
+
+	// down holds the nodes that have crashed or left
+	down map[string]bool
+	// groups maps each node to its side of a partition, nil if there isn't one
+	groups map[string]int
+	// rand makes the choices of the churn harness
+	rand *rand.Rand
+}
+
+// New returns an empty simulated network
+func New(config Config) *Simulator {
+	if config.Latency == nil {
+		config.Latency = Constant(10 * time.Millisecond)
+	}
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+	if config.Node == (kademlia.Config{}) {
+		config.Node = kademlia.DefaultConfig()
+	}
+	return &Simulator{
+		config: config,
+		byAddr: make(map[string]*kademlia.Node),
+		links:  make(map[[2]string]uint64),
+		down:   make(map[string]bool),
+		rand:   rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Now returns the simulated time
+func (s *Simulator) Now() time.Duration {
+	return s.now
+}
+
+// Stats returns the traffic sent so far
+func (s *Simulator) Stats() Stats {
+	return s.stats
+}
+
+// Len returns the number of nodes that have been added
+func (s *Simulator) Len() int {
+	return len(s.nodes)
+}
+
+// Node returns the i-th node added
+func (s *Simulator) Node(i int) *kademlia.Node {
+	return s.nodes[i]
+}
+
+// Addr returns the address of the i-th node added
+func (s *Simulator) Addr(i int) string {
+	return s.addrs[i]
+}
+
+// AddNode creates a node and joins it to the network through a live node
+// chosen from the ones already added, returning its index
+func (s *Simulator) AddNode() int {
+	i := len(s.nodes)
+	addr := fmt.Sprintf("10.%d.%d.%d:4000", (i>>16)&0xff, (i>>8)&0xff, i&0xff)
+	node := kademlia.NewNodeWithTransport(addr, s.config.Node, &transport{s, addr})
+	node.SetLogger(log.New(ioutil.Discard, "", 0))
+
+	bootstrap := ""
+	if live := s.Live(); len(live) > 0 {
+		bootstrap = s.addrs[live[hashRand(s.config.Seed, uint64(i), "join").Intn(len(live))]]
+	}
+	s.nodes = append(s.nodes, node)
+	s.addrs = append(s.addrs, addr)
+	s.byAddr[addr] = node
+	node.Join(bootstrap)
+	return i
+}
+
+// AddNodes adds n nodes, one after another
+func (s *Simulator) AddNodes(n int) {
+	for i := 0; i < n; i++ {
+		s.AddNode()
+	}
+}
+
+// Store has node from store val under key on the k closest nodes, and returns
+// how many accepted it
+func (s *Simulator) Store(from int, key string, val []byte) (int, error) {
+	return s.nodes[from].IterativeStore(key, val)
+}
+
+// FindValue has node from look up key
+func (s *Simulator) FindValue(from int, key string) Result {
+	start := s.now
+	value, stats := s.nodes[from].IterativeFindValue(key)
+	return Result{Value: value, Stats: stats, Latency: s.now - start}
+}
+
+// FindNode has node from look up the k closest nodes to id, and returns them
+// and how long the lookup took
+func (s *Simulator) FindNode(from int, id string) ([]kademlia.Contact, time.Duration) {
+	start := s.now
+	contacts := s.nodes[from].IterativeFindNode(id)
+	return contacts, s.now - start
+}
+
+// event is something scheduled to happen at a simulated time
+type event struct {
+	at  time.Duration
+	seq uint64
+	fn  func()
+}
+
+// eventQueue is a heap of events, earliest first and in the order they were
+// scheduled for events at the same time
+type eventQueue []event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Schedule arranges for fn to run at simulated time at. Events run one at a
+// time: each starts with the clock at its scheduled time, and its RPCs advance
+// the clock from there.
+func (s *Simulator) Schedule(at time.Duration, fn func()) {
+	s.seq++
+	heap.Push(&s.events, event{at, s.seq, fn})
+}
+
+// After arranges for fn to run d after the current simulated time
+func (s *Simulator) After(d time.Duration, fn func()) {
+	s.Schedule(s.now+d, fn)
+}
+
+// RunUntil runs the events scheduled up to and including end, leaving the
+// clock at end
+func (s *Simulator) RunUntil(end time.Duration) {
+	for len(s.events) > 0 && s.events[0].at <= end {
+		e := heap.Pop(&s.events).(event)
+		s.now = e.at
+		e.fn()
+	}
+	s.now = end
+}
+
+// Run runs every scheduled event, including any they schedule
+func (s *Simulator) Run() {
+	for len(s.events) > 0 {
+		e := heap.Pop(&s.events).(event)
+		s.now = e.at
+		e.fn()
+	}
+}
@@ -0,0 +1,158 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/peterdelong/kademlia"
+)
+
+// transport is the kademlia.Transport of one simulated node. RPCs are gob
+// encoded as they would be by net/rpc, delivered straight to the destination
+// node's handler, and charged to the virtual clock.
+type transport struct {
+	sim  *Simulator
+	addr string
+}
+
+func encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func decode(payload []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(payload)).Decode(v)
+}
+
+// delay returns how long a message of size bytes takes from src to dst, or
+// false if it's lost
+func (s *Simulator) delay(src string, dst string, size int) (time.Duration, bool) {
+	link := [2]string{src, dst}
+	n := s.links[link]
+	s.links[link] = n + 1
+	s.stats.Messages++
+	s.stats.Bytes += int64(size)
+
+	r := hashRand(s.config.Seed, n, src, dst)
+	if s.config.Loss > 0 && r.Float64() < s.config.Loss {
+		s.stats.Lost++
+		return 0, false
+	}
+	d := s.config.Latency(r)
+	if s.config.Bandwidth > 0 {
+		d += time.Duration(float64(size) / s.config.Bandwidth * float64(time.Second))
+	}
+	return d, true
+}
+
+func (t *transport) Call(method string, dest net.TCPAddr, args interface{}, reply interface{}) bool {
+	s := t.sim
+	s.stats.RPCs++
+	request, err := encode(args)
+	if err != nil {
+		panic(fmt.Sprintf("sim: encoding %s args: %s", method, err))
+	}
+
+	target, ok := s.byAddr[dest.String()]
+	d1, delivered := s.delay(t.addr, dest.String(), len(request))
+	if !ok || !delivered || !s.reachable(t.addr, dest.String()) {
+		s.stats.Failed++
+		s.now += s.config.Timeout
+		return false
+	}
+
+	response, err := deliver(target, method, request)
+	if err != nil {
+		panic(fmt.Sprintf("sim: %s RPC to %s: %s", method, dest.String(), err))
+	}
+	d2, delivered := s.delay(dest.String(), t.addr, len(response))
+	if !delivered {
+		s.stats.Failed++
+		s.now += s.config.Timeout
+		return false
+	}
+
+	s.now += d1 + d2
+	if err := decode(response, reply); err != nil {
+		panic(fmt.Sprintf("sim: decoding %s reply: %s", method, err))
+	}
+	return true
+}
+
+// Go runs fn straight away, but it doesn't hold up the caller, so the clock is
+// put back afterwards
+func (t *transport) Go(fn func()) {
+	start := t.sim.now
+	fn()
+	t.sim.now = start
+}
+
+// Parallel runs each fn in turn from the same start time, and leaves the clock
+// at the time the slowest one finished
+func (t *transport) Parallel(n int, fn func(i int)) {
+	start := t.sim.now
+	end := start
+	for i := 0; i < n; i++ {
+		t.sim.now = start
+		fn(i)
+		if t.sim.now > end {
+			end = t.sim.now
+		}
+	}
+	t.sim.now = end
+}
+
+// deliver decodes request as the arguments to method, runs the handler on node
+// and returns the encoded reply, as the NodeRPC stubs do for net/rpc
+func deliver(node *kademlia.Node, method string, request []byte) ([]byte, error) {
+	var reply interface{}
+	switch method {
+	case "Ping":
+		var args kademlia.PingArgs
+		if err := decode(request, &args); err != nil {
+			return nil, err
+		}
+		r := new(kademlia.PingReply)
+		node.Ping(args, r)
+		reply = r
+	case "Store":
+		var args kademlia.StoreArgs
+		if err := decode(request, &args); err != nil {
+			return nil, err
+		}
+		r := new(kademlia.StoreReply)
+		node.Store(args, r)
+		reply = r
+	case "FindValue":
+		var args kademlia.FindValueArgs
+		if err := decode(request, &args); err != nil {
+			return nil, err
+		}
+		r := new(kademlia.FindValueReply)
+		node.FindValue(args, r)
+		reply = r
+	case "FindNode":
+		var args kademlia.FindNodeArgs
+		if err := decode(request, &args); err != nil {
+			return nil, err
+		}
+		r := new(kademlia.FindNodeReply)
+		node.FindNode(args, r)
+		reply = r
+	case "Delete":
+		var args kademlia.DeleteArgs
+		if err := decode(request, &args); err != nil {
+			return nil, err
+		}
+		r := new(kademlia.DeleteReply)
+		node.Delete(args, r)
+		reply = r
+	default:
+		return nil, fmt.Errorf("unknown RPC %s", method)
+	}
+	return encode(reply)
+}
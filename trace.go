@@ -0,0 +1,206 @@
+package kademlia
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LookupTrace records each round of an iterative lookup: who was asked, how
+// long they took and what they said, and why the lookup stopped
+type LookupTrace struct {
+	// ID identifies the trace in the node's ring buffer (see /traces)
+	ID uint64 `json:"id"`
+	// Kind is "findnode" or "findvalue"
+	Kind  string    `json:"kind"`
+	Key   string    `json:"key"`
+	Start time.Time `json:"start"`
+	// DurationMs is how long the whole lookup took
+	DurationMs float64      `json:"duration_ms"`
+	Rounds     []TraceRound `json:"rounds"`
+	// Result is why the lookup stopped: "local" if we held the value,
+	// "found", "deleted", or "converged" once the shortlist stopped changing
+	Result string `json:"result"`
+
+	mu sync.Mutex
+	// done is set once the lookup has returned. RPCs still outstanding
+	// after that aren't recorded, so a finished trace never changes.
+	done bool
+}
+
+// TraceRound is one round of RPCs sent by a lookup
+type TraceRound struct {
+	// Shortlist is the shortlist the round started from, closest first
+	Shortlist []string     `json:"shortlist"`
+	Queries   []TraceQuery `json:"queries"`
+}
+
+// TraceQuery is one RPC sent by a lookup and its reply
+type TraceQuery struct {
+	Peer      string  `json:"peer"`
+	LatencyMs float64 `json:"latency_ms"`
+	// Error is set if the peer didn't answer or its answer was discarded
+	Error string `json:"error,omitempty"`
+	// Contacts are the contacts the peer returned, closest first
+	Contacts []string `json:"contacts,omitempty"`
+	// Value is set if the peer returned the value, and Deleted if it said
+	// the key was deleted
+	Value   bool `json:"value,omitempty"`
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// begin records the start of a lookup. All of the methods of a nil trace do
+// nothing, so lookups that aren't traced can call them freely.
+func (t *LookupTrace) begin(kind string, key string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Kind = kind
+	t.Key = key
+	t.Start = time.Now()
+}
+
+// round starts a round of RPCs sent from shortlist and returns its index
+func (t *LookupTrace) round(shortlist []Contact) int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Rounds = append(t.Rounds, TraceRound{Shortlist: addresses(shortlist), Queries: make([]TraceQuery, 0)})
+	return len(t.Rounds) - 1
+}
+
+// query records an RPC to peer sent at start in round
+func (t *LookupTrace) query(round int, peer net.TCPAddr, start time.Time, q TraceQuery) {
+	if t == nil {
+		return
+	}
+	q.Peer = peer.String()
+	q.LatencyMs = milliseconds(time.Since(start))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done || round < 0 || round >= len(t.Rounds) {
+		return
+	}
+	t.Rounds[round].Queries = append(t.Rounds[round].Queries, q)
+}
+
+// stop records why the lookup stopped, unless a reason was already given
+func (t *LookupTrace) stop(result string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Result == "" {
+		t.Result = result
+	}
+}
+
+// finish marks the trace as complete, stopping with "converged" if no other
+// reason was given
+func (t *LookupTrace) finish() {
+	if t == nil {
+		return
+	}
+	t.stop("converged")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.DurationMs = milliseconds(time.Since(t.Start))
+	t.done = true
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// addresses returns the address of each contact
+func addresses(contacts []Contact) []string {
+	result := make([]string, len(contacts))
+	for i, contact := range contacts {
+		result[i] = contact.Addr.String()
+	}
+	return result
+}
+
+// traceRing holds the most recent traceBufferSize finished traces
+type traceRing struct {
+	mu     sync.Mutex
+	traces []*LookupTrace
+	// next is where the next trace goes, once the ring is full
+	next   int
+	lastID uint64
+}
+
+// add gives trace an ID and puts it in the ring, in place of the oldest
+// trace if the ring is full
+func (r *traceRing) add(trace *LookupTrace) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastID++
+	trace.mu.Lock()
+	trace.ID = r.lastID
+	trace.mu.Unlock()
+	if len(r.traces) < traceBufferSize {
+		r.traces = append(r.traces, trace)
+		return
+	}
+	r.traces[r.next] = trace
+	r.next = (r.next + 1) % traceBufferSize
+}
+
+// list returns the traces in the ring, newest first
+func (r *traceRing) list() []*LookupTrace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]*LookupTrace, 0, len(r.traces))
+	for i := len(r.traces) - 1; i >= 0; i-- {
+		result = append(result, r.traces[(r.next+i)%len(r.traces)])
+	}
+	return result
+}
+
+// get returns the trace with id, or nil if it has left the ring
+func (r *traceRing) get(id uint64) *LookupTrace {
+	for _, trace := range r.list() {
+		if trace.ID == id {
+			return trace
+		}
+	}
+	return nil
+}
+
+// traceRequested returns true if r asks for its lookup to be traced with
+// ?trace=1
+func traceRequested(r *http.Request) bool {
+	trace, _ := strconv.ParseBool(r.URL.Query().Get("trace"))
+	return trace
+}
+
+func (node *Node) handleTraces(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod([]string{"GET"}, r, w) {
+		return
+	}
+
+	idString := r.URL.Path[len("/traces"):]
+	if idString == "" || idString == "/" {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"traces": node.traces.list()})
+		return
+	}
+	id, err := strconv.ParseUint(idString[1:], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad_id", "trace ID %q is not a number", idString[1:])
+		return
+	}
+	trace := node.traces.get(id)
+	if trace == nil {
+		writeError(w, http.StatusNotFound, "not_found", "trace %d is not in the last %d", id, traceBufferSize)
+		return
+	}
+	writeJSON(w, http.StatusOK, trace)
+}
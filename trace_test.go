@@ -0,0 +1,189 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// localTransport delivers RPCs straight to the nodes it holds, one at a time
+type localTransport struct {
+	nodes map[string]*Node
+}
+
+func (t localTransport) Call(method string, dest net.TCPAddr, args interface{}, reply interface{}) bool {
+	node, ok := t.nodes[dest.String()]
+	if !ok {
+		return false
+	}
+	rpc := &NodeRPC{node}
+	var err error
+	switch method {
+	case "Ping":
+		err = rpc.Ping(args.(PingArgs), reply.(*PingReply))
+	case "Store":
+		err = rpc.Store(args.(StoreArgs), reply.(*StoreReply))
+	case "FindValue":
+		err = rpc.FindValue(args.(FindValueArgs), reply.(*FindValueReply))
+	case "FindNode":
+		err = rpc.FindNode(args.(FindNodeArgs), reply.(*FindNodeReply))
+	case "Delete":
+		err = rpc.Delete(args.(DeleteArgs), reply.(*DeleteReply))
+	default:
+		return false
+	}
+	return err == nil
+}
+
+func (localTransport) Go(fn func()) { fn() }
+func (localTransport) Parallel(n int, fn func(i int)) {
+	for i := 0; i < n; i++ {
+		fn(i)
+	}
+}
+
+// chain returns nodes that each only know the next, and one address that
+// every node knows but nobody answers at
+func chain(t *testing.T, n int) ([]*Node, net.TCPAddr) {
+	transport := localTransport{make(map[string]*Node)}
+	nodes := make([]*Node, n)
+	for i := range nodes {
+		contact := testContact(i)
+		addr := contact.Addr.String()
+		nodes[i] = NewNodeWithTransport(addr, DefaultConfig(), transport)
+		if nodes[i] == nil {
+			t.Fatalf("couldn't create node at %s", addr)
+		}
+		nodes[i].SetLogger(log.New(ioutil.Discard, "", 0))
+		transport.nodes[addr] = nodes[i]
+	}
+	dead := testContact(n).Addr
+	for i, node := range nodes {
+		if i+1 < n {
+			node.rt.add(*NewContact(nodes[i+1].addr))
+		}
+		node.rt.add(*NewContact(dead))
+	}
+	return nodes, dead
+}
+
+func TestTraceFindValue(t *testing.T) {
+	nodes, dead := chain(t, 3)
+	key := ContentKey([]byte("value"))
+	nodes[2].ht.add(key, []byte("value"), false)
+
+	stats := LookupStats{Trace: new(LookupTrace)}
+	if value := nodes[0].doIterativeFindValueStats(key, false, &stats); string(value) != "value" {
+		t.Fatalf("lookup returned %q", value)
+	}
+	trace := stats.Trace
+	if trace.Kind != "findvalue" || trace.Key != key || trace.Result != "found" {
+		t.Errorf("trace is a %s of %s that ended %q", trace.Kind, trace.Key, trace.Result)
+	}
+	if len(trace.Rounds) != stats.Rounds || len(trace.Rounds) < 2 {
+		t.Fatalf("trace has %d rounds, stats %d", len(trace.Rounds), stats.Rounds)
+	}
+	// the first round asks the node we know and the dead address
+	queries := make(map[string]TraceQuery)
+	for _, q := range trace.Rounds[0].Queries {
+		queries[q.Peer] = q
+	}
+	if q := queries[dead.String()]; q.Error != "no reply" {
+		t.Errorf("query of the dead address recorded as %+v", q)
+	}
+	if q := queries[nodes[1].addr.String()]; q.Error != "" || len(q.Contacts) == 0 {
+		t.Errorf("query of the next node recorded as %+v", q)
+	}
+	last := trace.Rounds[len(trace.Rounds)-1].Queries
+	if q := last[len(last)-1]; !q.Value || q.Peer != nodes[2].addr.String() {
+		t.Errorf("last query recorded as %+v", q)
+	}
+	if got := nodes[0].traces.get(trace.ID); got != trace {
+		t.Errorf("trace %d isn't in the ring buffer", trace.ID)
+	}
+
+	// a value we hold ourselves ends the lookup at once
+	stats = LookupStats{Trace: new(LookupTrace)}
+	nodes[2].doIterativeFindValueStats(key, false, &stats)
+	if stats.Trace.Result != "local" || len(stats.Trace.Rounds) != 0 {
+		t.Errorf("local lookup ended %q after %d rounds", stats.Trace.Result, len(stats.Trace.Rounds))
+	}
+}
+
+func TestTraceFindNode(t *testing.T) {
+	nodes, _ := chain(t, 3)
+	stats := LookupStats{Trace: new(LookupTrace)}
+	nodes[0].doIterativeFindNodeStats(nodes[2].id.String(), &stats)
+	if stats.Trace.Kind != "findnode" || stats.Trace.Result != "converged" {
+		t.Errorf("trace is a %s that ended %q", stats.Trace.Kind, stats.Trace.Result)
+	}
+	if len(stats.Trace.Rounds) != stats.Rounds || stats.Rounds == 0 {
+		t.Errorf("trace has %d rounds, stats %d", len(stats.Trace.Rounds), stats.Rounds)
+	}
+	// lookups that aren't traced aren't kept unless TraceLookups is set
+	nodes[0].doIterativeFindNode(nodes[1].id.String())
+	nodes[0].config.TraceLookups = true
+	nodes[0].doIterativeFindNode(nodes[1].id.String())
+	if traces := nodes[0].traces.list(); len(traces) != 2 || traces[0].ID != 2 {
+		t.Errorf("ring buffer holds %d traces", len(traces))
+	}
+}
+
+func TestTraceRing(t *testing.T) {
+	var ring traceRing
+	for i := 0; i < traceBufferSize+10; i++ {
+		ring.add(new(LookupTrace))
+	}
+	traces := ring.list()
+	if len(traces) != traceBufferSize {
+		t.Fatalf("ring holds %d traces, want %d", len(traces), traceBufferSize)
+	}
+	for i, trace := range traces {
+		if want := uint64(traceBufferSize + 10 - i); trace.ID != want {
+			t.Fatalf("trace %d has ID %d, want %d", i, trace.ID, want)
+		}
+	}
+	if ring.get(10) != nil || ring.get(11) == nil {
+		t.Error("the oldest traces weren't the ones dropped")
+	}
+}
+
+func TestTraceEndpoints(t *testing.T) {
+	nodes, _ := chain(t, 3)
+	key := ContentKey([]byte("value"))
+	nodes[2].ht.add(key, []byte("value"), false)
+
+	get := func(handler func(http.ResponseWriter, *http.Request), path string, v interface{}) int {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", path, nil))
+		json.Unmarshal(w.Body.Bytes(), v)
+		return w.Code
+	}
+	var reply struct {
+		Value []byte
+		Trace LookupTrace
+	}
+	get(nodes[0].handleIterativeFindValue, "/iterative/findvalue/"+key+"?trace=1", &reply)
+	if string(reply.Value) != "value" || reply.Trace.Result != "found" || reply.Trace.ID != 1 {
+		t.Errorf("traced lookup returned %q with trace %d ending %q", reply.Value, reply.Trace.ID, reply.Trace.Result)
+	}
+
+	var trace LookupTrace
+	if code := get(nodes[0].handleTraces, "/traces/1", &trace); code != http.StatusOK || trace.Key != key {
+		t.Errorf("GET /traces/1 returned %d for %q", code, trace.Key)
+	}
+	var list struct{ Traces []LookupTrace }
+	if code := get(nodes[0].handleTraces, "/traces", &list); code != http.StatusOK || len(list.Traces) != 1 {
+		t.Errorf("GET /traces returned %d with %d traces", code, len(list.Traces))
+	}
+	if code := get(nodes[0].handleTraces, "/traces/2", &trace); code != http.StatusNotFound {
+		t.Errorf("GET of a missing trace returned %d", code)
+	}
+	if code := get(nodes[0].handleTraces, "/traces/x", &trace); code != http.StatusBadRequest {
+		t.Errorf("GET of a bad trace ID returned %d", code)
+	}
+}
@@ -0,0 +1,60 @@
+package kademlia
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+)
+
+// Transport carries RPCs from a node to its peers. Nodes normally use
+// rpcTransport, which sends them over net/rpc. The simulator in the sim package
+// supplies its own to deliver them in-process.
+type Transport interface {
+	// Call sends the RPC method (one of the NodeRPC methods) to dest and
+	// fills in reply, returning false if it failed
+	Call(method string, dest net.TCPAddr, args interface{}, reply interface{}) bool
+	// Go runs fn in the background
+	Go(fn func())
+	// Parallel runs fn(0) ... fn(n-1) concurrently. It may return before or
+	// after they finish, so results must be passed back through channels with
+	// room for all n of them.
+	Parallel(n int, fn func(i int))
+}
+
+// rpcTransport sends RPCs over net/rpc using goroutines for concurrency
+type rpcTransport struct {
+	node *Node
+}
+
+// Perform the legwork of RPC invocation
+func (t *rpcTransport) Call(method string, dest net.TCPAddr, args interface{}, reply interface{}) bool {
+	node := t.node
+	node.logs.rpc.Debug("Sending RPC", "method", method, "peer", dest.String())
+
+	client, err := rpc.DialHTTP("tcp", dest.String())
+	node.metrics.dialed(err == nil)
+	if err != nil {
+		node.logs.rpc.Warn("Dial failed", "peer", dest.String(), "err", err)
+		return false
+	}
+	defer node.metrics.closed()
+	defer client.Close()
+
+	err = client.Call(fmt.Sprintf("NodeRPC.%s", method), args, reply)
+	if err != nil {
+		node.logs.rpc.Warn("RPC failed", "method", method, "peer", dest.String(), "err", err)
+		return false
+	}
+
+	return true
+}
+
+func (t *rpcTransport) Go(fn func()) {
+	go fn()
+}
+
+func (t *rpcTransport) Parallel(n int, fn func(i int)) {
+	for i := 0; i < n; i++ {
+		go fn(i)
+	}
+}
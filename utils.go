@@ -2,8 +2,8 @@ package kademlia
 
 import (
 	"crypto/sha1"
-	"fmt"
-	"math/big"
+	"crypto/sha256"
+	"encoding/hex"
 	"net"
 )
 
@@ -25,23 +25,72 @@ func RemoveDupesFromShortlist(contacts []Contact) []Contact {
 
 // GetKBucketFromAddr returns the KBucket that would contain destAddr
 func (node *Node) GetKBucketFromAddr(destAddr net.TCPAddr) int {
-	hash := sha1.Sum([]byte(destAddr.String()))
+	return node.GetKBucketFromID(NodeIDFromString(destAddr.String()))
+}
 
-	id := *big.NewInt(0)
-	id.SetBytes(hash[:])
+// GetKBucketFromID returns the KBucket that would contain destID, the floor of
+// log2 of its distance from the node. The node's own ID has no bucket, so it
+// returns -1.
+func (node *Node) GetKBucketFromID(destID NodeID) int {
+	return node.id.Xor(destID).BitLen() - 1
+}
 
-	return node.GetKBucketFromID(&id)
+// bucketRange returns the first and last IDs in bucket index, those that
+// share the node's ID above bit index and differ from it at bit index
+func (node *Node) bucketRange(index int) (NodeID, NodeID) {
+	first, last := node.id, node.id
+	for bit := 0; bit <= index; bit++ {
+		i, mask := len(first)-1-bit/8, byte(1)<<(bit%8)
+		if bit == index {
+			first[i] ^= mask
+			last[i] ^= mask
+		} else {
+			first[i] &^= mask
+			last[i] |= mask
+		}
+	}
+	return first, last
 }
 
-// GetKBucketFromID returns the KBucket that would contain destID
-func (node *Node) GetKBucketFromID(destID *big.Int) int {
-	destContact := Contact{*destID, net.TCPAddr{}}
-	dist := node.distanceTo(&destContact)
-	node.logger.Printf("Distance is %s", dist)
+// ContentKey returns the content-addressed key for val, the hash of the value
+// in hex
+func ContentKey(val []byte) string {
+	if contentHashSHA256 {
+		hash := sha256.Sum256(val)
+		return hex.EncodeToString(hash[:sha1.Size])
+	}
+	hash := sha1.Sum(val)
+	return hex.EncodeToString(hash[:])
+}
 
-	// a kludgy hack to the get the floor of log_2 of the distance
-	bitstring := fmt.Sprintf("%b", dist)
-	log2Floor := len(bitstring) - 1
+// isContentKey returns true if key is the content-addressed key for val
+func isContentKey(key string, val []byte) bool {
+	id, err := ParseKey(key)
+	if err != nil {
+		return false
+	}
+	return id == keyID(ContentKey(val))
+}
+
+// NameKey returns the key a human-readable name is stored under, the SHA-1
+// hash of the name in hex
+func NameKey(name string) string {
+	hash := sha1.Sum([]byte(name))
+	return hex.EncodeToString(hash[:])
+}
+
+// isNameKey returns true if key is the key for name
+func isNameKey(key string, name string) bool {
+	id, err := ParseKey(key)
+	if err != nil {
+		return false
+	}
+	return id == keyID(NameKey(name))
+}
 
-	return log2Floor
+// OwnerHash returns the hash stored alongside a value for the publisher's
+// delete secret
+func OwnerHash(secret []byte) []byte {
+	hash := sha1.Sum(secret)
+	return hash[:]
 }